@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sort"
+)
+
+// Set at build time by the Makefile, with -ldflags "-X main.version=..."
+var (
+	version   = "unknown"
+	gitCommit = "unknown"
+	buildTime = "unknown"
+)
+
+// appVersion is the version the binary was built as. app_version is only used if the build didn't set one, e.g. with
+// go run, so that configuration can't misreport what's running.
+func appVersion() string {
+	if version != "unknown" {
+		return version
+	}
+	return config.AppVersion
+}
+
+// featureFlags are the optional behaviours reported by GET /info, and whether the configuration turns them on
+var featureFlags = map[string]func() bool{
+	"auto-migrate":         func() bool { return config.DB.AutoMigrate },
+	"tracing":              func() bool { return config.Tracing.Exporter != "none" },
+	"log-sampling":         func() bool { return config.Log.SamplingInitial > 0 },
+	"exercise-transitions": func() bool { return config.Transitions.Enabled },
+}
+
+// enabledFeatures returns the names of the feature flags that are on, sorted
+func enabledFeatures() []string {
+	enabled := []string{}
+	for name, on := range featureFlags {
+		if on() {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	return enabled
+}
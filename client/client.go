@@ -0,0 +1,352 @@
+// Package client is a Go client for the survey service API described in openapi.yaml.
+//
+//	c := client.New("http://ras-rm-survey", client.WithBasicAuth("admin", "secret"))
+//	survey, err := c.GetSurvey(ctx, "141")
+//	if errors.Is(err, client.ErrSurveyNotFound) {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+)
+
+// Client calls the survey service. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	username   string
+	password   string
+	retries    int
+	backoff    time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sends requests with h instead of a client with a 30 second timeout
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) { c.httpClient = h }
+}
+
+// WithBasicAuth sends the given credentials with every request
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) { c.username, c.password = username, password }
+}
+
+// WithRetries retries requests that are safe to repeat up to n times, if they fail to connect or get a 502, 503 or
+// 504, waiting backoff and then twice as long each time. The default is 2 retries after 100ms.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = n, backoff }
+}
+
+// New returns a client for the service at baseURL, e.g. http://ras-rm-survey:8080
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		retries:    2,
+		backoff:    100 * time.Millisecond,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// SurveyFilter selects surveys for FindSurveys. Empty fields aren't used, but at least one must be set.
+type SurveyFilter struct {
+	SurveyRef string
+	ShortName string
+	LongName  string
+}
+
+func (f SurveyFilter) query(includeInstruments bool) string {
+	query := url.Values{}
+	for key, value := range map[string]string{"reference": f.SurveyRef, "shortName": f.ShortName, "longName": f.LongName} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if includeInstruments {
+		query.Set("include", "instruments")
+	}
+	return query.Encode()
+}
+
+// FindSurveys returns the surveys matching every field set in filter
+func (c *Client) FindSurveys(ctx context.Context, filter SurveyFilter) ([]models.Survey, error) {
+	var results models.Surveys
+	if err := c.do(ctx, http.MethodGet, "/survey?"+filter.query(false), nil, &results); err != nil {
+		return nil, err
+	}
+	surveys := make([]models.Survey, len(results.Data))
+	for i, result := range results.Data {
+		surveys[i] = result.Survey
+	}
+	return surveys, nil
+}
+
+// FindSurveysWithInstruments returns the surveys matching every field set in filter, each with its collection
+// instruments
+func (c *Client) FindSurveysWithInstruments(ctx context.Context, filter SurveyFilter) ([]models.SurveyWithInstruments, error) {
+	var results models.Surveys
+	err := c.do(ctx, http.MethodGet, "/survey?"+filter.query(true), nil, &results)
+	return results.Data, err
+}
+
+// GetSurvey returns the survey with the given reference
+func (c *Client) GetSurvey(ctx context.Context, surveyRef string) (models.Survey, error) {
+	var survey models.Survey
+	err := c.do(ctx, http.MethodGet, "/survey/"+url.PathEscape(surveyRef), nil, &survey)
+	return survey, err
+}
+
+// GetSurveyWithInstruments returns the survey with the given reference and its collection instruments
+func (c *Client) GetSurveyWithInstruments(ctx context.Context, surveyRef string) (models.SurveyWithInstruments, error) {
+	var survey models.SurveyWithInstruments
+	err := c.do(ctx, http.MethodGet, "/survey/"+url.PathEscape(surveyRef)+"?include=instruments", nil, &survey)
+	return survey, err
+}
+
+// CreateSurvey creates a survey, returning it with the ID the service gave it
+func (c *Client) CreateSurvey(ctx context.Context, survey models.Survey) (models.Survey, error) {
+	var created models.Survey
+	err := c.do(ctx, http.MethodPost, "/survey", survey, &created)
+	return created, err
+}
+
+// UpdateSurvey changes the non-empty fields of changes on the survey with the given reference, returning the result
+func (c *Client) UpdateSurvey(ctx context.Context, surveyRef string, changes models.Survey) (models.Survey, error) {
+	body := map[string]string{}
+	for key, value := range map[string]string{"shortName": changes.ShortName, "longName": changes.LongName, "legalBasis": changes.LegalBasis, "surveyMode": changes.SurveyMode} {
+		if value != "" {
+			body[key] = value
+		}
+	}
+
+	var updated models.Survey
+	err := c.do(ctx, http.MethodPatch, "/survey/"+url.PathEscape(surveyRef), body, &updated)
+	return updated, err
+}
+
+// DeleteSurvey deletes the survey with the given reference
+func (c *Client) DeleteSurvey(ctx context.Context, surveyRef string) error {
+	return c.do(ctx, http.MethodDelete, "/survey/"+url.PathEscape(surveyRef), nil, nil)
+}
+
+// ExerciseFilter selects collection exercises for FindExercises. Empty fields match every exercise.
+type ExerciseFilter struct {
+	SurveyRef string
+	State     string
+}
+
+func (f ExerciseFilter) query(verbose bool) string {
+	query := url.Values{}
+	for key, value := range map[string]string{"reference": f.SurveyRef, "state": f.State} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if verbose {
+		query.Set("verbose", "true")
+	}
+	return query.Encode()
+}
+
+// FindExercises returns the collection exercises matching every field set in filter
+func (c *Client) FindExercises(ctx context.Context, filter ExerciseFilter) ([]models.CollectionExercise, error) {
+	var exercises models.CollectionExercises
+	err := c.do(ctx, http.MethodGet, "/collectionexercise?"+filter.query(false), nil, &exercises)
+	return exercises.Data, err
+}
+
+// FindExerciseDetails returns the collection exercises matching every field set in filter, each with its survey and
+// instruments
+func (c *Client) FindExerciseDetails(ctx context.Context, filter ExerciseFilter) ([]models.CollectionExerciseDetail, error) {
+	var details models.CollectionExerciseDetails
+	err := c.do(ctx, http.MethodGet, "/collectionexercise?"+filter.query(true), nil, &details)
+	return details.Data, err
+}
+
+// GetExercise returns the collection exercise with the given UUID
+func (c *Client) GetExercise(ctx context.Context, exerciseUUID string) (models.CollectionExercise, error) {
+	var exercise models.CollectionExercise
+	err := c.do(ctx, http.MethodGet, "/collectionexercise/"+url.PathEscape(exerciseUUID), nil, &exercise)
+	return exercise, err
+}
+
+// GetExerciseDetail returns the collection exercise with the given UUID, with its survey and instruments
+func (c *Client) GetExerciseDetail(ctx context.Context, exerciseUUID string) (models.CollectionExerciseDetail, error) {
+	var detail models.CollectionExerciseDetail
+	err := c.do(ctx, http.MethodGet, "/collectionexercise/"+url.PathEscape(exerciseUUID)+"?verbose=true", nil, &detail)
+	return detail, err
+}
+
+// CreateExercise creates a collection exercise in the CREATED state, returning it with the UUID the service gave it
+func (c *Client) CreateExercise(ctx context.Context, exercise models.CollectionExercise) (models.CollectionExercise, error) {
+	exercise.ExerciseUUID, exercise.State = "", ""
+	var created models.CollectionExercise
+	err := c.do(ctx, http.MethodPost, "/collectionexercise", exercise, &created)
+	return created, err
+}
+
+// CloneExercise copies the collection exercise with the given UUID to a new period, with its dates and emails moved
+// on and the same instruments, returning the copy
+func (c *Client) CloneExercise(ctx context.Context, exerciseUUID string, clone models.CloneExercise) (models.CollectionExercise, error) {
+	var created models.CollectionExercise
+	err := c.do(ctx, http.MethodPost, "/collectionexercise/"+url.PathEscape(exerciseUUID)+"/clone", clone, &created)
+	return created, err
+}
+
+// GenerateExercises creates the collection exercises the survey's schedule gives for the periods starting between from
+// and to, skipping periods the survey already has
+func (c *Client) GenerateExercises(ctx context.Context, surveyRef string, from time.Time, to time.Time) (models.GeneratedExercises, error) {
+	query := url.Values{"from": {from.Format("2006-01-02")}, "to": {to.Format("2006-01-02")}}
+	var generated models.GeneratedExercises
+	err := c.do(ctx, http.MethodPost, "/survey/"+url.PathEscape(surveyRef)+"/collectionexercise/generate?"+query.Encode(), nil, &generated)
+	return generated, err
+}
+
+// GetExerciseTimeline returns the dates and scheduled emails of the collection exercise with the given UUID, in time
+// order
+func (c *Client) GetExerciseTimeline(ctx context.Context, exerciseUUID string) (models.Timeline, error) {
+	var timeline models.Timeline
+	err := c.do(ctx, http.MethodGet, "/collectionexercise/"+url.PathEscape(exerciseUUID)+"/timeline", nil, &timeline)
+	return timeline, err
+}
+
+// InstrumentFilter selects collection instruments for FindInstruments. Empty fields match every instrument, and an
+// instrument's classifiers must include every one in Classifiers.
+type InstrumentFilter struct {
+	SurveyRef      string
+	InstrumentType string
+	Classifiers    map[string]string
+}
+
+func (f InstrumentFilter) query() string {
+	query := url.Values{}
+	for key, value := range map[string]string{"survey": f.SurveyRef, "type": f.InstrumentType} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	for name, value := range f.Classifiers {
+		query.Set("classifier."+name, value)
+	}
+	return query.Encode()
+}
+
+// FindInstruments returns the collection instruments matching every field set in filter, e.g. the EQ instrument for
+// a survey's form type
+func (c *Client) FindInstruments(ctx context.Context, filter InstrumentFilter) ([]models.CollectionInstrument, error) {
+	var instruments models.CollectionInstruments
+	err := c.do(ctx, http.MethodGet, "/collectioninstrument?"+filter.query(), nil, &instruments)
+	return instruments.Data, err
+}
+
+// do sends a request with body encoded as JSON, retrying if it's safe to, and decodes a successful response into
+// result. Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("couldn't encode the request body: %w", err)
+		}
+	}
+
+	resp, err := c.roundTrip(ctx, method, path, payload, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, result)
+}
+
+// roundTrip sends a request, retrying if it's safe to, and returns the last response
+func (c *Client) roundTrip(ctx context.Context, method string, path string, payload []byte, accept string) (*http.Response, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		var contentType string
+		if payload != nil {
+			body, contentType = bytes.NewReader(payload), "application/json"
+		}
+		resp, err := c.send(ctx, method, path, body, contentType, accept)
+		if attempt < c.retries && idempotent(method) && retryable(resp, err) {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			backoff *= 2
+			continue
+		}
+		return resp, err
+	}
+}
+
+func (c *Client) send(ctx context.Context, method string, path string, body io.Reader, contentType string, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return c.httpClient.Do(req)
+}
+
+func decodeResponse(resp *http.Response, result interface{}) error {
+	if resp.StatusCode >= http.StatusBadRequest {
+		var body models.ExerciseExistsError
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			body.Message = http.StatusText(resp.StatusCode)
+		}
+		return &Error{StatusCode: resp.StatusCode, RESTError: body.RESTError, ExerciseUUID: body.ExerciseUUID}
+	}
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("couldn't decode the response: %w", err)
+	}
+	return nil
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
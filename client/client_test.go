@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRetriesUnavailableResponses(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"reference":"141","shortName":"ASHE"}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithRetries(2, time.Millisecond))
+	survey, err := c.GetSurvey(context.Background(), "141")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ASHE", survey.ShortName)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestPostIsNotRetried(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithRetries(2, time.Millisecond))
+	_, err := c.CreateSurvey(context.Background(), models.Survey{SurveyRef: "141"})
+
+	var apiErr *Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRequestsCarryBasicAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin", username)
+		assert.Equal(t, "secret", password)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithBasicAuth("admin", "secret"))
+	assert.NoError(t, c.DeleteSurvey(context.Background(), "141"))
+}
+
+func TestErrorsMatchTheirCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":"SURVEY_NOT_FOUND","message":"Survey reference not found","requestId":"abc-123"}`))
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL).GetSurvey(context.Background(), "141")
+
+	assert.True(t, errors.Is(err, ErrSurveyNotFound))
+	assert.False(t, errors.Is(err, ErrInternalError))
+	assert.EqualError(t, err, "survey service returned 404 SURVEY_NOT_FOUND: Survey reference not found (request abc-123)")
+}
+
+func TestRetriesStopWhenTheContextIsDone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := New(server.URL, WithRetries(10, time.Second)).GetSurvey(ctx, "141")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestDownloadInstrumentFileChecksDigest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The SHA-256 of an empty file
+		w.Header().Set("Digest", "SHA-256=47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=")
+		w.Header().Set("Content-Disposition", "attachment; filename=seft_instrument.xls")
+		w.Write([]byte("corrupted"))
+	}))
+	defer server.Close()
+
+	file, err := New(server.URL).DownloadInstrumentFile(context.Background(), "ddc37cb6-c88a-473b-949a-fa5fad9265a1")
+	if err != nil {
+		t.Fatal("Error downloading the file, ", err.Error())
+	}
+	defer file.Body.Close()
+	_, err = ioutil.ReadAll(file.Body)
+
+	assert.Equal(t, ErrDigestMismatch, err)
+	assert.Equal(t, "seft_instrument.xls", file.Filename)
+}
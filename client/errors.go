@@ -0,0 +1,53 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+)
+
+// Error is an error response from the service
+type Error struct {
+	StatusCode int
+	models.RESTError
+	// ExerciseUUID is the existing collection exercise, when creating one fails with ErrExerciseExists
+	ExerciseUUID string
+}
+
+func (e *Error) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("survey service returned %d %s: %s (request %s)", e.StatusCode, e.Code, e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("survey service returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is matches errors with the same code, so callers can use errors.Is(err, client.ErrSurveyNotFound)
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Errors for each code the service returns, for use with errors.Is
+var (
+	ErrConflict             = codeError(models.CodeConflict)
+	ErrDatabaseUnavailable  = codeError(models.CodeDatabaseUnavailable)
+	ErrExerciseExists       = codeError(models.CodeExerciseExists)
+	ErrExerciseNotFound     = codeError(models.CodeExerciseNotFound)
+	ErrFileTooLarge         = codeError(models.CodeFileTooLarge)
+	ErrInstrumentNotFound   = codeError(models.CodeInstrumentNotFound)
+	ErrInternalError        = codeError(models.CodeInternalError)
+	ErrInvalidExerciseDates = codeError(models.CodeInvalidExerciseDates)
+	ErrInvalidFile          = codeError(models.CodeInvalidFile)
+	ErrInvalidJSON          = codeError(models.CodeInvalidJSON)
+	ErrInvalidParameter     = codeError(models.CodeInvalidParameter)
+	ErrInvalidRequest       = codeError(models.CodeInvalidRequest)
+	ErrNoExerciseSchedule   = codeError(models.CodeNoExerciseSchedule)
+	ErrNoValuesToUpdate     = codeError(models.CodeNoValuesToUpdate)
+	ErrRequestCancelled     = codeError(models.CodeRequestCancelled)
+	ErrRequestTimeout       = codeError(models.CodeRequestTimeout)
+	ErrSurveyNotFound       = codeError(models.CodeSurveyNotFound)
+)
+
+func codeError(code string) *Error {
+	return &Error{RESTError: models.RESTError{Code: code}}
+}
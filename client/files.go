@@ -0,0 +1,124 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+)
+
+// ErrDigestMismatch is returned when reading an InstrumentFile to the end if its contents don't match the digest the
+// service sent
+var ErrDigestMismatch = errors.New("the file doesn't match its digest")
+
+// InstrumentFile is a SEFT instrument's file being downloaded. Body must be closed.
+type InstrumentFile struct {
+	Filename    string
+	ContentType string
+	// Size is -1 if the service didn't send it
+	Size int64
+	// SHA256 is the digest recorded when the file was uploaded. Body checks the file against it as it's read.
+	SHA256 []byte
+	Body   io.ReadCloser
+}
+
+// UploadInstrument adds a collection instrument to the survey, returning it with the UUID the service gave it. A SEFT
+// instrument's .xls or .xlsx file is streamed from file; EQ instruments have no file, so file is nil.
+func (c *Client) UploadInstrument(ctx context.Context, surveyRef string, instrument models.CollectionInstrument, filename string, file io.Reader) (models.CollectionInstrument, error) {
+	body, form := io.Pipe()
+	writer := multipart.NewWriter(form)
+	go func() {
+		form.CloseWithError(writeInstrumentForm(writer, instrument, filename, file))
+	}()
+
+	// Uploads aren't retried, as the file has been read
+	resp, err := c.send(ctx, http.MethodPost, "/survey/"+url.PathEscape(surveyRef)+"/collectioninstrument", body, writer.FormDataContentType(), "application/json")
+	if err != nil {
+		return models.CollectionInstrument{}, err
+	}
+	defer resp.Body.Close()
+
+	var created models.CollectionInstrument
+	err = decodeResponse(resp, &created)
+	return created, err
+}
+
+func writeInstrumentForm(form *multipart.Writer, instrument models.CollectionInstrument, filename string, file io.Reader) error {
+	part, err := form.CreateFormField("collectionInstrument")
+	if err != nil {
+		return err
+	}
+	if err = json.NewEncoder(part).Encode(instrument); err != nil {
+		return err
+	}
+	if file != nil {
+		if part, err = form.CreateFormFile("SEFTFile", filename); err != nil {
+			return err
+		}
+		if _, err = io.Copy(part, file); err != nil {
+			return err
+		}
+	}
+	return form.Close()
+}
+
+// DownloadInstrumentFile starts downloading the file of the SEFT instrument with the given UUID
+func (c *Client) DownloadInstrumentFile(ctx context.Context, instrumentUUID string) (*InstrumentFile, error) {
+	resp, err := c.roundTrip(ctx, http.MethodGet, "/collectioninstrument/"+url.PathEscape(instrumentUUID)+"/file", nil, "*/*")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, decodeResponse(resp, nil)
+	}
+
+	file := &InstrumentFile{ContentType: resp.Header.Get("Content-Type"), Size: resp.ContentLength, Body: resp.Body}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		file.Filename = params["filename"]
+	}
+	if file.SHA256 = digest(resp.Header.Get("Digest"), "SHA-256"); file.SHA256 != nil {
+		file.Body = &verifyingReader{ReadCloser: resp.Body, hash: sha256.New(), sum: file.SHA256}
+	}
+	return file, nil
+}
+
+// digest returns the value for the algorithm from a Digest header, e.g. SHA-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=
+func digest(header string, algorithm string) []byte {
+	for _, value := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(value), "=", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], algorithm) {
+			if sum, err := base64.StdEncoding.DecodeString(parts[1]); err == nil {
+				return sum
+			}
+		}
+	}
+	return nil
+}
+
+// verifyingReader fails with ErrDigestMismatch at the end of a body that doesn't match its digest
+type verifyingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	sum  []byte
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(v.hash.Sum(nil), v.sum) {
+		return n, ErrDigestMismatch
+	}
+	return n, err
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ONSdigital/ras-rm-survey/client"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupClient runs the real router, with response validation, behind a client
+func setupClient(t *testing.T) (*client.Client, sqlmock.Sqlmock) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return client.New(server.URL, client.WithRetries(0, 0)), mock
+}
+
+func TestClientFindSurveys(t *testing.T) {
+	c, mock := setupClient(t)
+
+	returnRows := mock.NewRows(searchSurveyQueryColumns)
+	returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "SEFT")
+	mock.ExpectQuery(findSurveyQuery).WithArgs("TS").WillReturnRows(returnRows)
+
+	surveys, err := c.FindSurveys(context.Background(), client.SurveyFilter{ShortName: "TS"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Survey{{ID: "8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", SurveyRef: "123", ShortName: "TS", LongName: "Test Survey", LegalBasis: "Test Legal Basis", SurveyMode: "SEFT"}}, surveys)
+}
+
+func TestClientGetSurveyNotFound(t *testing.T) {
+	c, mock := setupClient(t)
+
+	mock.ExpectQuery(findSurveyQuery).WillReturnRows(mock.NewRows(searchSurveyQueryColumns))
+
+	_, err := c.GetSurvey(context.Background(), "555")
+
+	assert.True(t, errors.Is(err, client.ErrSurveyNotFound))
+}
+
+func TestClientCreateSurvey(t *testing.T) {
+	c, mock := setupClient(t)
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare(postSurveyExec).ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	survey, err := c.CreateSurvey(context.Background(), models.Survey{SurveyRef: "156", ShortName: "NEW", LongName: "New Survey", LegalBasis: "Statistics of Trade Act 1947", SurveyMode: "EQ"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "156", survey.SurveyRef)
+	assert.NotEmpty(t, survey.ID)
+}
+
+func TestClientCreateSurveyRejectedBySpec(t *testing.T) {
+	c, _ := setupClient(t)
+
+	_, err := c.CreateSurvey(context.Background(), models.Survey{SurveyRef: "156"})
+
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest))
+}
+
+func TestClientUpdateSurvey(t *testing.T) {
+	c, mock := setupClient(t)
+
+	beforePatchReturnRows := mock.NewRows(searchSurveyQueryColumns)
+	beforePatchReturnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "SEFT")
+	afterPatchReturnRows := mock.NewRows(searchSurveyQueryColumns)
+	afterPatchReturnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "NEW", "Test Survey", "Test Legal Basis", "SEFT")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(findSurveyQuery).WillReturnRows(beforePatchReturnRows)
+	mock.ExpectPrepare(updateSurveyExec).ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(findSurveyQuery).WillReturnRows(afterPatchReturnRows)
+
+	survey, err := c.UpdateSurvey(context.Background(), "123", models.Survey{ShortName: "NEW"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "NEW", survey.ShortName)
+}
+
+func TestClientDeleteSurvey(t *testing.T) {
+	c, mock := setupClient(t)
+
+	returnRows := mock.NewRows(searchSurveyQueryColumns)
+	returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "SEFT")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(findSurveyQuery).WillReturnRows(returnRows)
+	mock.ExpectPrepare(deleteSurveyExec).ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, c.DeleteSurvey(context.Background(), "123"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClientGetExerciseDetail(t *testing.T) {
+	c, mock := setupClient(t)
+
+	returnRows := mock.NewRows(exerciseDetailColumnNames)
+	returnRows.AddRow("6f1bf642-2f9c-408f-8ffe-93b40667d99a", "141", "LIVE", "202009", nil, nil, nil, nil, nil, nil,
+		"8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT",
+		"ddc37cb6-c88a-473b-949a-fa5fad9265a1", "SEFT", []byte(`{"formType":"0001"}`), "seft_instrument.xls")
+	mock.ExpectQuery(findExerciseDetailsQuery).WithArgs("6f1bf642-2f9c-408f-8ffe-93b40667d99a").WillReturnRows(returnRows)
+
+	detail, err := c.GetExerciseDetail(context.Background(), "6f1bf642-2f9c-408f-8ffe-93b40667d99a")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "202009", detail.CollectionExercise.PeriodName)
+	assert.Equal(t, "ASHE", detail.Survey.ShortName)
+	assert.Equal(t, "seft_instrument.xls", detail.CollectionInstruments[0].SEFTFilename)
+}
+
+func TestClientFindExercisesNotFound(t *testing.T) {
+	c, mock := setupClient(t)
+
+	mock.ExpectQuery(findExercisesQuery).WithArgs("141").WillReturnRows(mock.NewRows(exerciseColumnNames))
+
+	_, err := c.FindExercises(context.Background(), client.ExerciseFilter{SurveyRef: "141"})
+
+	assert.True(t, errors.Is(err, client.ErrExerciseNotFound))
+}
+
+func TestClientGetSurveyWithInstruments(t *testing.T) {
+	c, mock := setupClient(t)
+
+	returnRows := mock.NewRows(surveyWithInstrumentsColumns)
+	returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT",
+		"ddc37cb6-c88a-473b-949a-fa5fad9265a1", "SEFT", nil, "seft_instrument.xls")
+	mock.ExpectQuery(findSurveyQuery).WithArgs("141").WillReturnRows(returnRows)
+
+	survey, err := c.GetSurveyWithInstruments(context.Background(), "141")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ASHE", survey.Survey.ShortName)
+	assert.Equal(t, "ddc37cb6-c88a-473b-949a-fa5fad9265a1", survey.CollectionInstruments[0].InstrumentUUID)
+}
+
+func TestClientCreateExerciseWithInvalidDates(t *testing.T) {
+	c, _ := setupClient(t)
+
+	mps := time.Date(2020, 9, 10, 0, 0, 0, 0, time.UTC)
+	goLive := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	_, err := c.CreateExercise(context.Background(), models.CollectionExercise{SurveyReference: "141", PeriodName: "202009", MPS: &mps, GoLive: &goLive})
+
+	assert.True(t, errors.Is(err, client.ErrInvalidExerciseDates))
+}
+
+func TestClientCreateExerciseForExistingPeriod(t *testing.T) {
+	c, mock := setupClient(t)
+
+	surveyRows := mock.NewRows(searchSurveyQueryColumns)
+	surveyRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(findSurveyQuery).WillReturnRows(surveyRows)
+	mock.ExpectPrepare(postExerciseExec).ExpectExec().WillReturnError(&pq.Error{Code: "23505", Constraint: "collection_exercise_survey_period_key"})
+	mock.ExpectRollback()
+	mock.ExpectQuery("SELECT exercise_uuid").WillReturnRows(mock.NewRows([]string{"exercise_uuid"}).AddRow("6f1bf642-2f9c-408f-8ffe-93b40667d99a"))
+
+	_, err := c.CreateExercise(context.Background(), models.CollectionExercise{SurveyReference: "141", PeriodName: "202009"})
+
+	var apiErr *client.Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.True(t, errors.Is(err, client.ErrExerciseExists))
+	assert.Equal(t, "6f1bf642-2f9c-408f-8ffe-93b40667d99a", apiErr.ExerciseUUID)
+}
+
+func TestClientCloneExerciseNotFound(t *testing.T) {
+	c, mock := setupClient(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(cloneExerciseSourceQuery).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	_, err := c.CloneExercise(context.Background(), "6f1bf642-2f9c-408f-8ffe-93b40667d99a", models.CloneExercise{PeriodName: "202010", ShiftMonths: 1})
+
+	assert.True(t, errors.Is(err, client.ErrExerciseNotFound))
+}
+
+func TestClientGenerateExercisesWithoutSchedule(t *testing.T) {
+	c, _ := setupClient(t)
+
+	_, err := c.GenerateExercises(context.Background(), "141", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC))
+
+	assert.True(t, errors.Is(err, client.ErrNoExerciseSchedule))
+}
+
+func TestClientFindInstruments(t *testing.T) {
+	c, mock := setupClient(t)
+
+	returnRows := mock.NewRows(instrumentColumnNames)
+	returnRows.AddRow("ddc37cb6-c88a-473b-949a-fa5fad9265a1", "EQ", []byte(`{"formType":"0001","eqID":"2"}`), nil)
+	mock.ExpectQuery("SELECT (.+) FROM surveyv2.collection_instrument ci (.+)").WithArgs("141", "EQ", `{"formType":"0001"}`).WillReturnRows(returnRows)
+
+	instruments, err := c.FindInstruments(context.Background(), client.InstrumentFilter{SurveyRef: "141", InstrumentType: "EQ", Classifiers: map[string]string{"formType": "0001"}})
+
+	assert.NoError(t, err)
+	assert.Len(t, instruments, 1)
+	assert.Equal(t, "ddc37cb6-c88a-473b-949a-fa5fad9265a1", instruments[0].InstrumentUUID)
+}
+
+func TestClientUploadAndDownloadInstrumentFile(t *testing.T) {
+	c, mock := setupClient(t)
+	var err error
+	if instrumentFiles, err = newDirStore(t.TempDir()); err != nil {
+		t.Fatal("Error setting up the file store, ", err.Error())
+	}
+	defer func() { instrumentFiles = nil }()
+
+	sum := sha256.Sum256(xlsxContents)
+	mock.ExpectBegin()
+	mock.ExpectQuery(findSurveyQuery).WithArgs("141").WillReturnRows(surveyRows(mock))
+	mock.ExpectExec(createInstrumentExec).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	created, err := c.UploadInstrument(context.Background(), "141", models.CollectionInstrument{InstrumentType: "SEFT"}, "seft_instrument.xlsx", bytes.NewReader(xlsxContents))
+	if err != nil {
+		t.Fatal("Error uploading the instrument, ", err.Error())
+	}
+	assert.Equal(t, "seft_instrument.xlsx", created.SEFTFilename)
+
+	mock.ExpectQuery(findInstrumentFileQuery).WithArgs(created.InstrumentUUID).
+		WillReturnRows(mock.NewRows([]string{"seft_filename", "file_size", "file_sha256"}).AddRow("seft_instrument.xlsx", len(xlsxContents), sum[:]))
+
+	file, err := c.DownloadInstrumentFile(context.Background(), created.InstrumentUUID)
+	if err != nil {
+		t.Fatal("Error downloading the file, ", err.Error())
+	}
+	defer file.Body.Close()
+	contents, err := ioutil.ReadAll(file.Body)
+
+	assert.NoError(t, err)
+	assert.Equal(t, xlsxContents, contents)
+	assert.Equal(t, "seft_instrument.xlsx", file.Filename)
+	assert.Equal(t, sum[:], file.SHA256)
+}
+
+func TestClientUploadInstrumentWithInvalidFile(t *testing.T) {
+	c, _ := setupClient(t)
+	var err error
+	if instrumentFiles, err = newDirStore(t.TempDir()); err != nil {
+		t.Fatal("Error setting up the file store, ", err.Error())
+	}
+	defer func() { instrumentFiles = nil }()
+
+	_, err = c.UploadInstrument(context.Background(), "141", models.CollectionInstrument{InstrumentType: "SEFT"}, "seft_instrument.csv", strings.NewReader("a,b,c"))
+
+	assert.True(t, errors.Is(err, client.ErrInvalidFile))
+}
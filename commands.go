@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/golang-migrate/migrate/v4"
+)
+
+const usage = `Usage: ras-rm-survey [-<setting> <value>]... <command> [arguments]
+
+Settings are read from, in increasing priority, their defaults, the YAML file named by -config_file, environment
+variables (the setting's name in upper case, e.g. DB_HOST) and flags (e.g. -db_host localhost). Run check-config to
+see them all.
+
+Commands:
+  serve                                  run migrations and start the HTTP server (default)
+  migrate up                             apply all outstanding migrations
+  migrate down [steps]                   roll back the given number of migrations (default 1)
+  migrate version                        print the current schema version
+  migrate force <version>                set the schema version without running migrations, clearing the dirty flag
+  survey list [-reference] [-shortName] [-longName]
+                                         list surveys matching the given filters
+  survey get <reference>                 print a single survey
+  survey create -reference -shortName -longName -legalBasis -surveyMode
+                                         create a survey
+  exercise transition <uuid> <state>     move a collection exercise into a new state
+  check-config                           print the effective configuration and check it is valid`
+
+// stdout is where commands write their output; it's swapped out in tests
+var stdout io.Writer = os.Stdout
+
+var errUsage = errors.New(usage)
+
+// runCommand dispatches the command-line arguments to the matching subcommand
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		return serve()
+	}
+
+	switch args[0] {
+	case "serve":
+		return serve()
+	case "migrate":
+		return withDB(func() error { return migrateCommand(args[1:]) })
+	case "survey":
+		return withDB(func() error { return surveyCommand(args[1:]) })
+	case "exercise":
+		return withDB(func() error { return exerciseCommand(args[1:]) })
+	case "check-config":
+		return checkConfigCommand()
+	case "help", "-h", "--help":
+		fmt.Fprintln(stdout, usage)
+		return nil
+	default:
+		return errUsage
+	}
+}
+
+// withDB opens the database connection for the duration of a command
+func withDB(command func() error) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if err := openDB(); err != nil {
+		return fmt.Errorf("couldn't connect to postgres: %w", err)
+	}
+	defer db.Close()
+	return command()
+}
+
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	m, err := newMigrate()
+	if err != nil {
+		return fmt.Errorf("couldn't set up migrations: %w", err)
+	}
+	defer m.Close()
+
+	// Hold the same lock as startup migrations so a command never runs alongside a deploying replica
+	err = withMigrationLock(context.Background(), func() error {
+		switch args[0] {
+		case "up":
+			if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+				return err
+			}
+		case "down":
+			steps := 1
+			if len(args) > 1 {
+				var err error
+				if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+					return fmt.Errorf("invalid number of steps %q", args[1])
+				}
+			}
+			return m.Steps(-steps)
+		case "version":
+		case "force":
+			if len(args) != 2 {
+				return errUsage
+			}
+			version, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid version %q", args[1])
+			}
+			return m.Force(version)
+		default:
+			return errUsage
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := m.Version()
+	if err == migrate.ErrNilVersion {
+		fmt.Fprintln(stdout, "no migrations applied")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "version %d, dirty %t\n", version, dirty)
+	return nil
+}
+
+func surveyCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("survey list", flag.ContinueOnError)
+		filters := map[string]*string{}
+		for param := range surveySearchColumns {
+			filters[param] = flags.String(param, "", "filter by "+param)
+		}
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		search := map[string]string{}
+		flags.Visit(func(f *flag.Flag) {
+			search[f.Name] = *filters[f.Name]
+		})
+
+		surveys, err := findSurveys(context.Background(), search)
+		if err != nil {
+			return err
+		}
+		return printJSON(surveys)
+	case "get":
+		if len(args) != 2 {
+			return errUsage
+		}
+		survey, err := selectSurvey(context.Background(), db, args[1])
+		if err != nil {
+			return err
+		}
+		return printJSON(survey)
+	case "create":
+		var survey models.Survey
+		flags := flag.NewFlagSet("survey create", flag.ContinueOnError)
+		flags.StringVar(&survey.SurveyRef, "reference", "", "the survey reference, e.g. 141")
+		flags.StringVar(&survey.ShortName, "shortName", "", "the survey short name")
+		flags.StringVar(&survey.LongName, "longName", "", "the survey long name")
+		flags.StringVar(&survey.LegalBasis, "legalBasis", "", "the legal basis of the survey")
+		flags.StringVar(&survey.SurveyMode, "surveyMode", "", "the survey mode, EQ or SEFT")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if survey.SurveyRef == "" {
+			return errors.New("-reference is required")
+		}
+
+		if err := createSurvey(context.Background(), &survey); err != nil {
+			return err
+		}
+		return printJSON(survey)
+	default:
+		return errUsage
+	}
+}
+
+func exerciseCommand(args []string) error {
+	if len(args) != 3 || args[0] != "transition" {
+		return errUsage
+	}
+	if err := transitionExercise(context.Background(), args[1], args[2]); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "collection exercise %s is now %s\n", args[1], args[2])
+	return nil
+}
+
+// checkConfigCommand prints every setting, with secrets redacted, and fails if any of them are unusable
+func checkConfigCommand() error {
+	for _, setting := range config.settings() {
+		fmt.Fprintln(stdout, setting)
+	}
+	return config.validate()
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
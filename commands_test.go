@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var exerciseStateQuery = "SELECT COALESCE\\(state, ''\\) FROM surveyv2.collection_exercise WHERE exercise_uuid = \\$1 FOR UPDATE"
+var transitionExerciseExec = "UPDATE (.+)collection_exercise SET state*"
+var transitionEventExec = "INSERT INTO surveyv2.exercise_event \\(exercise_uuid, event_type, from_state, to_state, traceparent\\) VALUES \\(\\$1, 'STATE_CHANGED', \\$2, \\$3, NULLIF\\(\\$4, ''\\)\\)"
+
+func setupCommand(t *testing.T) (sqlmock.Sqlmock, *bytes.Buffer) {
+	config = defaultConfig()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	output := &bytes.Buffer{}
+	stdout = output
+	return mock, output
+}
+
+func TestSurveyListCommand(t *testing.T) {
+	mock, output := setupCommand(t)
+
+	returnRows := mock.NewRows(searchSurveyQueryColumns)
+	returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "Test Survey Mode")
+
+	mock.ExpectQuery("SELECT (.+) FROM (.+) AND short_name = \\$1").WithArgs("TS").WillReturnRows(returnRows)
+
+	err := surveyCommand([]string{"list", "-shortName", "TS"})
+	assert.NoError(t, err)
+
+	var surveys []models.Survey
+	err = json.Unmarshal(output.Bytes(), &surveys)
+	if err != nil {
+		t.Fatal("Error decoding JSON output from 'survey list', ", err.Error())
+	}
+
+	assert.Equal(t, "123", surveys[0].SurveyRef)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSurveyGetCommandReturnsErrorWhenSurveyRefNotFound(t *testing.T) {
+	mock, _ := setupCommand(t)
+
+	mock.ExpectQuery(findSurveyQuery).WillReturnRows(mock.NewRows(searchSurveyQueryColumns))
+
+	err := surveyCommand([]string{"get", "555"})
+	assert.Equal(t, errSurveyNotFound, err)
+}
+
+func TestSurveyCreateCommand(t *testing.T) {
+	mock, output := setupCommand(t)
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare(postSurveyExec).ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err := surveyCommand([]string{"create", "-reference", "156", "-shortName", "NEWPOST3333", "-longName", "postsurvey"})
+	assert.NoError(t, err)
+
+	var survey models.Survey
+	err = json.Unmarshal(output.Bytes(), &survey)
+	if err != nil {
+		t.Fatal("Error decoding JSON output from 'survey create', ", err.Error())
+	}
+
+	assert.Equal(t, "NEWPOST3333", survey.ShortName)
+	assert.NotEmpty(t, survey.ID)
+}
+
+func TestExerciseTransitionCommand(t *testing.T) {
+	mock, output := setupCommand(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(exerciseStateQuery).WithArgs("6f1bf642-2f9c-408f-8ffe-93b40667d99a").
+		WillReturnRows(mock.NewRows([]string{"state"}).AddRow("READY_FOR_LIVE"))
+	mock.ExpectExec(transitionExerciseExec).WithArgs("LIVE", "6f1bf642-2f9c-408f-8ffe-93b40667d99a").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(transitionEventExec).WithArgs("6f1bf642-2f9c-408f-8ffe-93b40667d99a", "READY_FOR_LIVE", "LIVE", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err := exerciseCommand([]string{"transition", "6f1bf642-2f9c-408f-8ffe-93b40667d99a", "LIVE"})
+	assert.NoError(t, err)
+	assert.Contains(t, output.String(), "is now LIVE")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExerciseTransitionCommandReturnsErrorWhenExerciseNotFound(t *testing.T) {
+	mock, _ := setupCommand(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(exerciseStateQuery).WillReturnRows(mock.NewRows([]string{"state"}))
+	mock.ExpectRollback()
+
+	err := exerciseCommand([]string{"transition", "6f1bf642-2f9c-408f-8ffe-93b40667d99a", "LIVE"})
+	assert.Equal(t, errExerciseNotFound, err)
+}
+
+func TestExerciseTransitionCommandRejectsATransitionOffThePath(t *testing.T) {
+	mock, _ := setupCommand(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(exerciseStateQuery).WillReturnRows(mock.NewRows([]string{"state"}).AddRow("CREATED"))
+	mock.ExpectRollback()
+
+	err := exerciseCommand([]string{"transition", "6f1bf642-2f9c-408f-8ffe-93b40667d99a", "LIVE"})
+	assert.True(t, errors.Is(err, errInvalidTransition))
+	assert.EqualError(t, err, "collection exercise can't move to that state: CREATED to LIVE")
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing should be changed")
+}
+
+func TestExerciseTransitionCommandRejectsInvalidState(t *testing.T) {
+	setupCommand(t)
+
+	err := exerciseCommand([]string{"transition", "6f1bf642-2f9c-408f-8ffe-93b40667d99a", "FINISHED"})
+	assert.True(t, errors.Is(err, errInvalidState))
+}
+
+func TestCheckConfigCommandRedactsPassword(t *testing.T) {
+	_, output := setupCommand(t)
+
+	err := checkConfigCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, output.String(), "db_password=********")
+	assert.Contains(t, output.String(), "db_schema=surveyv2")
+}
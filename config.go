@@ -1,16 +1,347 @@
 package main
 
-import "github.com/spf13/viper"
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+	"github.com/spf13/viper"
+)
+
+// defaultSchema is the schema the service has always used, and the default for db_schema
+const defaultSchema = "surveyv2"
+
+// safeIdentifier matches an unquoted Postgres identifier that is safe to splice into SQL
+var safeIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// config is the configuration the service is running with, loaded once at startup by loadConfig
+var config Config
+
+// Config holds every setting. Each is named by its mapstructure tag, which is also its key in a config file, its
+// command line flag and, upper cased, its environment variable.
+type Config struct {
+	ServiceName         string `mapstructure:"service_name"`
+	AppVersion          string `mapstructure:"app_version"`
+	DummyHealthRabbitMQ string `mapstructure:"dummy_health_rabbitmq"`
+	ConfigFile          string `mapstructure:"config_file"`
+
+	// OpenAPIValidateResponses checks every response against openapi.yaml. It's for tests and development, as it
+	// holds each response in memory.
+	OpenAPIValidateResponses bool `mapstructure:"openapi_validate_responses"`
+
+	// ExerciseDateRules replaces the default collection exercise date rules for the surveys it lists, by reference.
+	// Maps can't be set in the environment, so it's only read from config_file.
+	ExerciseDateRules map[string][]string `mapstructure:"exercise_date_rules"`
+
+	// ExerciseSchedules are the cadences collection exercises are generated on, by survey reference. Like
+	// exercise_date_rules, they're only read from config_file.
+	ExerciseSchedules map[string]ExerciseSchedule `mapstructure:"exercise_schedules"`
+
+	DB      DBConfig      `mapstructure:",squash"`
+	HTTP    HTTPConfig    `mapstructure:",squash"`
+	Tracing TracingConfig `mapstructure:",squash"`
+	Log     logger.Config `mapstructure:",squash"`
+
+	Transitions TransitionsConfig `mapstructure:",squash"`
+	Events      EventsConfig      `mapstructure:",squash"`
+	Instruments InstrumentsConfig `mapstructure:",squash"`
+}
+
+// DBConfig is how to reach Postgres, and how hard to try
+type DBConfig struct {
+	Host              string        `mapstructure:"db_host"`
+	Port              int           `mapstructure:"db_port"`
+	Name              string        `mapstructure:"db_name"`
+	Username          string        `mapstructure:"db_username"`
+	Password          Secret        `mapstructure:"db_password"`
+	Schema            string        `mapstructure:"db_schema"`
+	AutoMigrate       bool          `mapstructure:"db_auto_migrate"`
+	SSLMode           string        `mapstructure:"db_sslmode"`
+	SSLRootCert       string        `mapstructure:"db_sslrootcert"`
+	MaxOpenConns      int           `mapstructure:"db_max_open_conns"`
+	MaxIdleConns      int           `mapstructure:"db_max_idle_conns"`
+	ConnMaxLifetime   time.Duration `mapstructure:"db_conn_max_lifetime"`
+	ConnectAttempts   int           `mapstructure:"db_connect_attempts"`
+	ConnectBackoff    time.Duration `mapstructure:"db_connect_backoff"`
+	ConnectMaxBackoff time.Duration `mapstructure:"db_connect_max_backoff"`
+}
+
+// HTTPConfig is how the HTTP server listens and how long it gives requests
+type HTTPConfig struct {
+	Port                  int           `mapstructure:"port"`
+	AdminPort             int           `mapstructure:"admin_port"`
+	ReadTimeout           time.Duration `mapstructure:"http_read_timeout"`
+	WriteTimeout          time.Duration `mapstructure:"http_write_timeout"`
+	IdleTimeout           time.Duration `mapstructure:"http_idle_timeout"`
+	ShutdownTimeout       time.Duration `mapstructure:"shutdown_timeout"`
+	RequestTimeout        time.Duration `mapstructure:"request_timeout"`
+	ReadinessCheckTimeout time.Duration `mapstructure:"readiness_check_timeout"`
+}
+
+// TracingConfig is where spans are sent, and how many
+type TracingConfig struct {
+	Exporter     string  `mapstructure:"tracing_exporter"`
+	OTLPEndpoint string  `mapstructure:"tracing_otlp_endpoint"`
+	OTLPInsecure bool    `mapstructure:"tracing_otlp_insecure"`
+	SampleRatio  float64 `mapstructure:"tracing_sample_ratio"`
+}
+
+// TransitionsConfig is whether, and how often, collection exercises are moved on automatically as their dates pass
+type TransitionsConfig struct {
+	Enabled  bool          `mapstructure:"exercise_transitions_enabled"`
+	Interval time.Duration `mapstructure:"exercise_transitions_interval"`
+}
+
+// EventsConfig is where recorded collection exercise events are published, and how often
+type EventsConfig struct {
+	// Publisher is none, log or http. With none, events are recorded but not published.
+	Publisher string        `mapstructure:"event_publisher"`
+	URL       string        `mapstructure:"event_publish_url"`
+	Interval  time.Duration `mapstructure:"event_publish_interval"`
+	Timeout   time.Duration `mapstructure:"event_publish_timeout"`
+	BatchSize int           `mapstructure:"event_publish_batch_size"`
+}
+
+// ExerciseSchedule is how a survey's collection exercises fall, as set in exercise_schedules. Offsets are ISO 8601
+// durations from the start of the period, e.g. -P10D for ten days before it. Dates without an offset aren't set.
+type ExerciseSchedule struct {
+	// Frequency is monthly, quarterly or annually. Periods start on the first of the month, of January, April, July
+	// and October, or of January.
+	Frequency string `mapstructure:"frequency"`
+	// PeriodName is the pattern for naming periods, where YYYY or YY is the year, MM the month the period starts in
+	// and Q the quarter, e.g. YYYYMM. Text in single quotes is left as it is, e.g. YYYY'Q'Q.
+	PeriodName string `mapstructure:"period_name"`
+	MPS        string `mapstructure:"mps"`
+	GoLive     string `mapstructure:"go_live"`
+	Return     string `mapstructure:"return"`
+}
+
+// InstrumentsConfig is where SEFT collection instrument files are kept, and how large they can be
+type InstrumentsConfig struct {
+	StorageDir    string `mapstructure:"instrument_storage_dir"`
+	MaxUploadSize int64  `mapstructure:"seft_max_upload_size"`
+
+	// FileTimeout replaces request_timeout, http_read_timeout and http_write_timeout for uploading or downloading a
+	// SEFT file, which can take minutes over a slow link
+	FileTimeout time.Duration `mapstructure:"instrument_file_timeout"`
+}
+
+// Secret is a setting that mustn't be shown. It prints and marshals as asterisks, so logging a Config is safe.
+type Secret string
+
+func (Secret) String() string {
+	return "********"
+}
+
+// MarshalJSON redacts the secret
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.String() + `"`), nil
+}
 
 func setDefaults() {
 	viper.SetDefault("service_name", "ras-rm-survey")
-	viper.SetDefault("app_version", "unknown")
-	viper.SetDefault("dummy_health_database", "UP 100ms")
+	viper.SetDefault("app_version", version)
 	viper.SetDefault("dummy_health_rabbitmq", "DOWN")
+	viper.SetDefault("config_file", "")
+	viper.SetDefault("openapi_validate_responses", false)
+	viper.SetDefault("exercise_date_rules", map[string][]string{})
+	viper.SetDefault("exercise_schedules", map[string]ExerciseSchedule{})
 	viper.SetDefault("db_host", "localhost")
-	viper.SetDefault("db_port", "5432")
+	viper.SetDefault("db_port", 5432)
 	viper.SetDefault("db_name", "ras")
 	viper.SetDefault("db_username", "postgres")
 	viper.SetDefault("db_password", "postgres")
-	viper.SetDefault("db_schema", "surveyv2")
+	viper.SetDefault("db_schema", defaultSchema)
+	viper.SetDefault("db_auto_migrate", true)
+	viper.SetDefault("db_sslmode", "disable")
+	viper.SetDefault("db_sslrootcert", "")
+	viper.SetDefault("db_max_open_conns", 10)
+	viper.SetDefault("db_max_idle_conns", 5)
+	viper.SetDefault("db_conn_max_lifetime", "30m")
+	viper.SetDefault("db_connect_attempts", 10)
+	viper.SetDefault("db_connect_backoff", "500ms")
+	viper.SetDefault("db_connect_max_backoff", "30s")
+	viper.SetDefault("port", 8080)
+	viper.SetDefault("admin_port", 8081)
+	viper.SetDefault("http_read_timeout", "10s")
+	viper.SetDefault("http_write_timeout", "30s")
+	viper.SetDefault("http_idle_timeout", "120s")
+	viper.SetDefault("shutdown_timeout", "20s")
+	viper.SetDefault("request_timeout", "10s")
+	viper.SetDefault("readiness_check_timeout", "2s")
+	viper.SetDefault("tracing_exporter", "none")
+	viper.SetDefault("tracing_otlp_endpoint", "")
+	viper.SetDefault("tracing_otlp_insecure", false)
+	viper.SetDefault("tracing_sample_ratio", 1.0)
+	viper.SetDefault("exercise_transitions_enabled", true)
+	viper.SetDefault("exercise_transitions_interval", "1m")
+	viper.SetDefault("event_publisher", "log")
+	viper.SetDefault("event_publish_url", "")
+	viper.SetDefault("event_publish_interval", "10s")
+	viper.SetDefault("event_publish_timeout", "5s")
+	viper.SetDefault("event_publish_batch_size", 100)
+	viper.SetDefault("instrument_storage_dir", "/var/lib/ras-rm-survey/instruments")
+	viper.SetDefault("seft_max_upload_size", 10<<20)
+	viper.SetDefault("instrument_file_timeout", "10m")
+	viper.SetDefault("log_level", "INFO")
+	viper.SetDefault("log_format", "json")
+	viper.SetDefault("log_sampling_initial", 100)
+	viper.SetDefault("log_sampling_thereafter", 100)
+	viper.SetDefault("gcp_project_id", "")
+}
+
+// parseFlags sets any settings given as flags before the command, e.g. -db_host localhost, and returns the
+// remaining arguments
+func parseFlags(args []string) ([]string, error) {
+	flags := flag.NewFlagSet("ras-rm-survey", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	for _, key := range viper.AllKeys() {
+		flags.String(key, "", "")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w\n\n%s", err, usage)
+	}
+	flags.Visit(func(f *flag.Flag) {
+		viper.Set(f.Name, f.Value.String())
+	})
+	return flags.Args(), nil
+}
+
+// loadConfig decodes the settings from, in increasing priority, the defaults, the YAML file named by config_file,
+// environment variables and flags. Settings that don't parse, and unknown keys in the file, are errors rather than
+// being ignored.
+func loadConfig() (Config, error) {
+	if file := viper.GetString("config_file"); file != "" {
+		viper.SetConfigFile(file)
+		viper.SetConfigType("yaml")
+		if err := viper.ReadInConfig(); err != nil {
+			return Config{}, fmt.Errorf("couldn't read config_file %s: %w", file, err)
+		}
+	}
+
+	var c Config
+	if err := viper.UnmarshalExact(&c); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return c, nil
+}
+
+// validate checks settings that would be unsafe or impossible to use as given, reporting every problem at once
+func (c Config) validate() error {
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	check(validateSchemaName(c.DB.Schema))
+	check(validateSSLMode(c.DB.SSLMode))
+	check(c.Log.Validate())
+	if c.DB.Port <= 0 || c.DB.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("db_port %d must be between 1 and 65535", c.DB.Port))
+	}
+	if c.HTTP.Port <= 0 || c.HTTP.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("port %d must be between 1 and 65535", c.HTTP.Port))
+	}
+	if c.HTTP.AdminPort <= 0 || c.HTTP.AdminPort > 65535 || c.HTTP.AdminPort == c.HTTP.Port {
+		problems = append(problems, fmt.Sprintf("admin_port %d must be between 1 and 65535, and not port", c.HTTP.AdminPort))
+	}
+	// Startup migrations hold a connection from the pool for the migration lock, so a pool of one has none to spare
+	if c.DB.AutoMigrate && c.DB.MaxOpenConns > 0 && c.DB.MaxOpenConns < 2 {
+		problems = append(problems, fmt.Sprintf("db_max_open_conns %d must be at least 2, or 0 for no limit, when db_auto_migrate is on", c.DB.MaxOpenConns))
+	}
+	if c.DB.ConnectAttempts < 1 {
+		problems = append(problems, "db_connect_attempts must be at least 1")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problems = append(problems, fmt.Sprintf("tracing_sample_ratio %g must be between 0 and 1", c.Tracing.SampleRatio))
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		problems = append(problems, fmt.Sprintf("tracing_exporter %q must be one of none, stdout or otlp", c.Tracing.Exporter))
+	}
+	for surveyRef, rules := range c.ExerciseDateRules {
+		for _, rule := range rules {
+			if _, err := parseDateRule(rule); err != nil {
+				problems = append(problems, fmt.Sprintf("exercise_date_rules for survey %s: %s", surveyRef, err))
+			}
+		}
+	}
+	switch c.Events.Publisher {
+	case "none", "log":
+	case "http":
+		if u, err := url.Parse(c.Events.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("event_publish_url %q must be an http or https URL when event_publisher is http", c.Events.URL))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("event_publisher %q must be one of none, log or http", c.Events.Publisher))
+	}
+	if c.Events.BatchSize < 1 {
+		problems = append(problems, "event_publish_batch_size must be at least 1")
+	}
+	if c.Instruments.StorageDir == "" {
+		problems = append(problems, "instrument_storage_dir must be set")
+	}
+	if c.Instruments.MaxUploadSize <= 0 {
+		problems = append(problems, "seft_max_upload_size must be positive")
+	}
+	for surveyRef, schedule := range c.ExerciseSchedules {
+		if _, err := parseSchedule(schedule); err != nil {
+			problems = append(problems, fmt.Sprintf("exercise_schedules for survey %s: %s", surveyRef, err))
+		}
+	}
+	for key, timeout := range map[string]time.Duration{
+		"request_timeout":               c.HTTP.RequestTimeout,
+		"shutdown_timeout":              c.HTTP.ShutdownTimeout,
+		"readiness_check_timeout":       c.HTTP.ReadinessCheckTimeout,
+		"exercise_transitions_interval": c.Transitions.Interval,
+		"event_publish_interval":        c.Events.Interval,
+		"event_publish_timeout":         c.Events.Timeout,
+		"instrument_file_timeout":       c.Instruments.FileTimeout,
+	} {
+		if timeout <= 0 {
+			problems = append(problems, key+" must be positive")
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+}
+
+func validateSchemaName(schema string) error {
+	if !safeIdentifier.MatchString(schema) {
+		return fmt.Errorf("db_schema %q must be a lower case Postgres identifier of letters, digits and underscores", schema)
+	}
+	return nil
+}
+
+// settings lists every setting as key=value, sorted by key, with secrets redacted
+func (c Config) settings() []string {
+	var lines []string
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("mapstructure"), ",")[0]
+			if name == "" {
+				walk(v.Field(i))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s=%v", name, v.Field(i).Interface()))
+		}
+	}
+	walk(reflect.ValueOf(c))
+	sort.Strings(lines)
+	return lines
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// defaultConfig loads the configuration from the defaults alone
+func defaultConfig() Config {
+	viper.Reset()
+	setDefaults()
+	c, err := loadConfig()
+	if err != nil {
+		panic("default configuration doesn't load: " + err.Error())
+	}
+	return c
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	file, err := ioutil.TempFile("", "config-*.yaml")
+	if err != nil {
+		t.Fatal("Error creating config file, ", err.Error())
+	}
+	defer file.Close()
+	t.Cleanup(func() { os.Remove(file.Name()) })
+
+	if _, err := file.WriteString(contents); err != nil {
+		t.Fatal("Error writing config file, ", err.Error())
+	}
+	return file.Name()
+}
+
+func TestDefaultConfigIsValid(t *testing.T) {
+	c := defaultConfig()
+
+	assert.NoError(t, c.validate())
+	assert.Equal(t, 5432, c.DB.Port)
+	assert.Equal(t, 10*time.Second, c.HTTP.RequestTimeout)
+	assert.Equal(t, "INFO", c.Log.Level)
+}
+
+func TestLoadConfigPriority(t *testing.T) {
+	defer viper.Reset()
+	viper.Reset()
+	setDefaults()
+
+	file := writeConfigFile(t, "db_host: file-host\ndb_name: file-name\nport: 9000\n")
+
+	os.Setenv("DB_NAME", "env-name")
+	defer os.Unsetenv("DB_NAME")
+	viper.AutomaticEnv()
+
+	args, err := parseFlags([]string{"-config_file", file, "-port", "9090", "survey", "get", "141"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"survey", "get", "141"}, args)
+
+	c, err := loadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "file-host", c.DB.Host)
+	assert.Equal(t, "env-name", c.DB.Name)
+	assert.Equal(t, 9090, c.HTTP.Port)
+}
+
+func TestLoadConfigRejectsUnknownFileKeys(t *testing.T) {
+	defer viper.Reset()
+	viper.Reset()
+	setDefaults()
+
+	viper.Set("config_file", writeConfigFile(t, "db_hots: typo\n"))
+
+	_, err := loadConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "db_hots")
+}
+
+func TestLoadConfigRejectsValuesThatDontParse(t *testing.T) {
+	defer viper.Reset()
+	viper.Reset()
+	setDefaults()
+	viper.Set("request_timeout", "ten seconds")
+
+	_, err := loadConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "request_timeout")
+}
+
+func TestParseFlagsRejectsUnknownSettings(t *testing.T) {
+	defer viper.Reset()
+	viper.Reset()
+	setDefaults()
+
+	_, err := parseFlags([]string{"-db_hots", "localhost"})
+	assert.Error(t, err)
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	c := defaultConfig()
+	c.DB.Schema = "Survey"
+	c.DB.SSLMode = "prefer"
+	c.Tracing.SampleRatio = 2
+
+	err := c.validate()
+	assert.EqualError(t, err, `invalid configuration:
+  db_schema "Survey" must be a lower case Postgres identifier of letters, digits and underscores
+  db_sslmode "prefer" must be one of disable, require, verify-ca, verify-full
+  tracing_sample_ratio 2 must be between 0 and 1`)
+}
+
+func TestSettingsRedactSecrets(t *testing.T) {
+	c := defaultConfig()
+	c.DB.Password = "it's a secret"
+
+	settings := c.settings()
+	assert.Contains(t, settings, "db_password=********")
+	assert.Contains(t, settings, "request_timeout=10s")
+	assert.NotContains(t, settings, "db_password=it's a secret")
+}
+
+func TestLoadConfigReadsExerciseDateRules(t *testing.T) {
+	defer viper.Reset()
+	viper.Reset()
+	setDefaults()
+
+	file := writeConfigFile(t, "exercise_date_rules:\n  \"141\":\n    - mps < go_live\n    - go_live <= return\n  \"142\":\n    - mps after go_live\n")
+	viper.Set("config_file", file)
+
+	c, err := loadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mps < go_live", "go_live <= return"}, c.ExerciseDateRules["141"])
+
+	err = c.validate()
+	assert.EqualError(t, err, "invalid configuration:\n  exercise_date_rules for survey 142: date rule \"mps after go_live\" must be written as <date> < <date> or <date> <= <date>")
+}
+
+func TestLoadConfigReadsExerciseSchedules(t *testing.T) {
+	defer viper.Reset()
+	viper.Reset()
+	setDefaults()
+
+	file := writeConfigFile(t, "exercise_schedules:\n  \"141\":\n    frequency: monthly\n    period_name: YYYYMM\n    go_live: PT9H\n  \"142\":\n    frequency: fortnightly\n    period_name: YYYYMM\n")
+	viper.Set("config_file", file)
+
+	c, err := loadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, ExerciseSchedule{Frequency: "monthly", PeriodName: "YYYYMM", GoLive: "PT9H"}, c.ExerciseSchedules["141"])
+
+	err = c.validate()
+	assert.EqualError(t, err, "invalid configuration:\n  exercise_schedules for survey 142: frequency \"fortnightly\" must be one of monthly, quarterly or annually")
+}
+
+func TestValidateRequiresAURLForTheHTTPEventPublisher(t *testing.T) {
+	c := defaultConfig()
+	c.Events.Publisher = "http"
+
+	err := c.validate()
+	assert.EqualError(t, err, "invalid configuration:\n  event_publish_url \"\" must be an http or https URL when event_publisher is http")
+
+	c.Events.URL = "https://events.example.com/exercises"
+	assert.NoError(t, c.validate())
+}
+
+func TestValidateRequiresTwoConnectionsToMigrateOnStartup(t *testing.T) {
+	c := defaultConfig()
+	c.DB.MaxOpenConns = 1
+
+	err := c.validate()
+	assert.EqualError(t, err, "invalid configuration:\n  db_max_open_conns 1 must be at least 2, or 0 for no limit, when db_auto_migrate is on")
+
+	c.DB.AutoMigrate = false
+	assert.NoError(t, c.validate())
+}
@@ -1 +1,8 @@
-DROP SCHEMA IF EXISTS surveyv2 CASCADE;
\ No newline at end of file
+DROP TABLE IF EXISTS {{ .Schema }}.email;
+DROP TABLE IF EXISTS {{ .Schema }}.associated_instruments;
+DROP TABLE IF EXISTS {{ .Schema }}.collection_instrument;
+DROP TABLE IF EXISTS {{ .Schema }}.collection_exercise;
+DROP TABLE IF EXISTS {{ .Schema }}.survey;
+
+-- Deliberately not cascading, so anything else created in the schema is never dropped by accident
+DROP SCHEMA IF EXISTS {{ .Schema }};
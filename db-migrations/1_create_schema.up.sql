@@ -1,17 +1,15 @@
-DROP SCHEMA IF EXISTS surveyv2 CASCADE;
+CREATE SCHEMA IF NOT EXISTS {{ .Schema }};
 
-CREATE SCHEMA surveyv2;
-
-CREATE TABLE IF NOT EXISTS surveyv2.survey (
+CREATE TABLE IF NOT EXISTS {{ .Schema }}.survey (
     id uuid PRIMARY KEY,
-    survey_ref text,
+    survey_ref text UNIQUE,
     short_name text,
     long_name text,
     legal_basis text,
     survey_mode text
 );
 
-CREATE TABLE IF NOT EXISTS surveyv2.collection_exercise (
+CREATE TABLE IF NOT EXISTS {{ .Schema }}.collection_exercise (
     exercise_id serial PRIMARY KEY,
     survey_ref text NOT NULL,
     state text,
@@ -23,31 +21,31 @@ CREATE TABLE IF NOT EXISTS surveyv2.collection_exercise (
     period_end timestamp,
     employment timestamp,
     return timestamp,
-    FOREIGN KEY (survey_ref) REFERENCES surveyv2.survey (survey_ref)
+    FOREIGN KEY (survey_ref) REFERENCES {{ .Schema }}.survey (survey_ref)
 );
 
-CREATE TABLE IF NOT EXISTS surveyv2.collection_instrument (
+CREATE TABLE IF NOT EXISTS {{ .Schema }}.collection_instrument (
     instrument_id serial PRIMARY KEY,
     survey_ref text NOT NULL,
     instrument_uuid uuid NOT NULL,
     type text,
     classifiers jsonb,
     seft_filename text,
-    FOREIGN KEY (survey_ref) REFERENCES surveyv2.survey (survey_ref)
+    FOREIGN KEY (survey_ref) REFERENCES {{ .Schema }}.survey (survey_ref)
 );
 
-CREATE TABLE IF NOT EXISTS surveyv2.associated_instruments (
+CREATE TABLE IF NOT EXISTS {{ .Schema }}.associated_instruments (
     exercise_id int NOT NULL,
     instrument_id int NOT NULL,
     PRIMARY KEY (exercise_id, instrument_id),
-    FOREIGN KEY (exercise_id) REFERENCES surveyv2.collection_exercise (exercise_id),
-    FOREIGN KEY (instrument_id) REFERENCES surveyv2.collection_instrument (instrument_id)
+    FOREIGN KEY (exercise_id) REFERENCES {{ .Schema }}.collection_exercise (exercise_id),
+    FOREIGN KEY (instrument_id) REFERENCES {{ .Schema }}.collection_instrument (instrument_id)
 );
 
-CREATE TABLE IF NOT EXISTS surveyv2.email (
+CREATE TABLE IF NOT EXISTS {{ .Schema }}.email (
     email_id serial PRIMARY KEY,
     exercise_id int NOT NULL,
     type text,
     time_scheduled timestamp,
-    FOREIGN KEY (exercise_id) REFERENCES surveyv2.collection_exercise (exercise_id)
+    FOREIGN KEY (exercise_id) REFERENCES {{ .Schema }}.collection_exercise (exercise_id)
 );
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- Each change of a collection exercise's state is recorded here, in the same transaction as the change, so it can be
+-- published later without being lost. published_at is set once it has been.
+CREATE TABLE IF NOT EXISTS {{ .Schema }}.exercise_event (
+    event_id bigserial PRIMARY KEY,
+    exercise_uuid uuid NOT NULL,
+    event_type text NOT NULL,
+    from_state text,
+    to_state text NOT NULL,
+    occurred_at timestamptz NOT NULL DEFAULT now(),
+    published_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS exercise_event_unpublished ON {{ .Schema }}.exercise_event (event_id) WHERE published_at IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE {{ .Schema }}.collection_exercise DROP CONSTRAINT IF EXISTS collection_exercise_survey_period_key;
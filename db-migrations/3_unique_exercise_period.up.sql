@@ -0,0 +1,3 @@
+-- A survey has one collection exercise per period. This fails if there are already duplicates, which need merging
+-- or renaming first.
+ALTER TABLE {{ .Schema }}.collection_exercise ADD CONSTRAINT collection_exercise_survey_period_key UNIQUE (survey_ref, period_name);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS {{ .Schema }}.collection_instrument_classifiers;
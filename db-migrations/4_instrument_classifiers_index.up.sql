@@ -0,0 +1,3 @@
+-- Instruments are looked up by their classifiers with jsonb containment (@>), e.g. the instrument for a form type.
+-- jsonb_path_ops is smaller and faster than the default operator class, and containment is all it needs to serve.
+CREATE INDEX IF NOT EXISTS collection_instrument_classifiers ON {{ .Schema }}.collection_instrument USING GIN (classifiers jsonb_path_ops);
@@ -0,0 +1,2 @@
+ALTER TABLE {{ .Schema }}.collection_instrument DROP COLUMN IF EXISTS file_sha256;
+ALTER TABLE {{ .Schema }}.collection_instrument DROP COLUMN IF EXISTS file_size;
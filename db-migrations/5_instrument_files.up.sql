@@ -0,0 +1,4 @@
+-- The size and SHA-256 of a SEFT instrument's file, recorded when it's uploaded. The file itself is kept in
+-- instrument_storage_dir, named by the instrument's UUID.
+ALTER TABLE {{ .Schema }}.collection_instrument ADD COLUMN IF NOT EXISTS file_size bigint;
+ALTER TABLE {{ .Schema }}.collection_instrument ADD COLUMN IF NOT EXISTS file_sha256 bytea;
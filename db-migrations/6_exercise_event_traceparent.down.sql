@@ -0,0 +1 @@
+ALTER TABLE {{ .Schema }}.exercise_event DROP COLUMN IF EXISTS traceparent;
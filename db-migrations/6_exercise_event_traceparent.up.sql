@@ -0,0 +1,2 @@
+-- The W3C traceparent of the span that recorded each event, so publishing it continues the same trace
+ALTER TABLE {{ .Schema }}.exercise_event ADD COLUMN IF NOT EXISTS traceparent text;
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+)
+
+var db *sql.DB
+
+// sslModes are the sslmode values lib/pq understands
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// openDB configures the connection pool and waits for Postgres to accept connections
+func openDB() error {
+	var err error
+	db, err = sql.Open("postgres", dataSourceName())
+	if err != nil {
+		return err
+	}
+
+	db.SetMaxOpenConns(config.DB.MaxOpenConns)
+	db.SetMaxIdleConns(config.DB.MaxIdleConns)
+	db.SetConnMaxLifetime(config.DB.ConnMaxLifetime)
+
+	// sql.Open is lazy, so make sure the database can actually be reached before carrying on
+	return waitForDB(context.Background())
+}
+
+// dataSourceName builds the lib/pq connection string from configuration
+func dataSourceName() string {
+	settings := []struct{ key, value string }{
+		{"host", config.DB.Host},
+		{"port", strconv.Itoa(config.DB.Port)},
+		{"dbname", config.DB.Name},
+		{"user", config.DB.Username},
+		{"password", string(config.DB.Password)},
+		{"sslmode", config.DB.SSLMode},
+		{"sslrootcert", config.DB.SSLRootCert},
+	}
+
+	var parts []string
+	for _, setting := range settings {
+		if setting.value != "" {
+			parts = append(parts, setting.key+"="+quoteConnValue(setting.value))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteConnValue quotes a connection string value so spaces or quotes in, say, a password can't break it
+func quoteConnValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// waitForDB pings the database until it responds, backing off exponentially between attempts
+func waitForDB(ctx context.Context) error {
+	attempts := config.DB.ConnectAttempts
+	backoff := config.DB.ConnectBackoff
+	maxBackoff := config.DB.ConnectMaxBackoff
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = db.PingContext(ctx); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		logger.Logger.Warnf("Couldn't reach postgres (attempt %d of %d), retrying in %s: %s", attempt, attempts, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	return fmt.Errorf("postgres unreachable after %d attempts: %w", attempts, err)
+}
+
+func validateSSLMode(mode string) error {
+	for _, m := range sslModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("db_sslmode %q must be one of %s", mode, strings.Join(sslModes, ", "))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataSourceName(t *testing.T) {
+	config = defaultConfig()
+	config.DB.Password = `it's a secret`
+	config.DB.SSLMode = "verify-full"
+	config.DB.SSLRootCert = "/var/secrets/db/root.crt"
+
+	assert.Equal(t, `host='localhost' port='5432' dbname='ras' user='postgres' password='it\'s a secret' sslmode='verify-full' sslrootcert='/var/secrets/db/root.crt'`, dataSourceName())
+}
+
+func TestWaitForDBRetriesUntilPostgresIsUp(t *testing.T) {
+	config = defaultConfig()
+	config.DB.ConnectBackoff = time.Millisecond
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectPing()
+
+	assert.NoError(t, waitForDB(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWaitForDBGivesUp(t *testing.T) {
+	config = defaultConfig()
+	config.DB.ConnectAttempts = 2
+	config.DB.ConnectBackoff = time.Millisecond
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+	err = waitForDB(context.Background())
+	assert.EqualError(t, err, "postgres unreachable after 2 attempts: connection refused")
+}
+
+func TestValidateSSLMode(t *testing.T) {
+	assert.NoError(t, validateSSLMode("disable"))
+	assert.NoError(t, validateSSLMode("verify-full"))
+	assert.Error(t, validateSSLMode("prefer"))
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+)
+
+// docs is the API documentation also published to GitHub Pages, built into the binary so each environment serves
+// the spec for the version it is running
+//
+//go:embed openapi.yaml index.html dist/*.js dist/*.css dist/*.png dist/*.html
+var docs embed.FS
+
+func showOpenAPI(w http.ResponseWriter, r *http.Request) {
+	spec, err := docs.ReadFile("openapi.yaml")
+	if err != nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeInternalError, "Couldn't read the OpenAPI spec")
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(spec)
+}
+
+// docsHandler serves Swagger UI under /docs/. Its index.html loads dist/ and openapi.yaml with relative URLs, so
+// they're served alongside it.
+func docsHandler() http.Handler {
+	return http.StripPrefix("/docs/", http.FileServer(http.FS(docs)))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPIEndpoint(t *testing.T) {
+	setup()
+
+	req := httptest.NewRequest("GET", "/openapi.yaml", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/yaml", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Body.String(), "openapi: 3.0.3")
+}
+
+func TestDocsEndpointServesSwaggerUI(t *testing.T) {
+	setup()
+
+	req := httptest.NewRequest("GET", "/docs/", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `url: "openapi.yaml"`)
+
+	for _, asset := range []string{"/docs/dist/swagger-ui-bundle.js", "/docs/dist/swagger-ui.css", "/docs/openapi.yaml"} {
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest("GET", asset, nil))
+		assert.Equal(t, http.StatusOK, resp.Code, asset)
+	}
+}
+
+func TestDocsEndpointRedirectsToTrailingSlash(t *testing.T) {
+	setup()
+
+	req := httptest.NewRequest("GET", "/docs", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusMovedPermanently, resp.Code)
+	assert.Equal(t, "/docs/", resp.Header().Get("Location"))
+}
@@ -2,33 +2,73 @@ package main
 
 import (
 	"encoding/json"
-	"io/ioutil"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
-	"time"
-	"database/sql"
-	"strings"
+	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
-    "github.com/ONSdigital/ras-rm-survey/logger"
-    "github.com/gofrs/uuid"
+	"github.com/ONSdigital/ras-rm-survey/logger"
 	"github.com/ONSdigital/ras-rm-survey/models"
 	"github.com/gorilla/mux"
-	"github.com/spf13/viper"
 )
 
 func handleEndpoints(r *mux.Router) {
+	r.Use(traceRequests, logRequests, instrumentRequests, requestTimeout, validateOpenAPI)
+	r.Handle("/metrics", metricsHandler()).Methods("GET")
 	r.HandleFunc("/info", showInfo).Methods("GET")
+	r.HandleFunc("/openapi.yaml", showOpenAPI).Methods("GET")
+	r.Handle("/docs", http.RedirectHandler("/docs/", http.StatusMovedPermanently)).Methods("GET")
+	r.PathPrefix("/docs/").Handler(docsHandler()).Methods("GET")
 	r.HandleFunc("/health", showHealth).Methods("GET")
+	r.HandleFunc("/health/live", showLive).Methods("GET")
+	r.HandleFunc("/health/ready", showReady).Methods("GET")
 	r.HandleFunc("/survey", getSurvey).Methods("GET")
 	r.HandleFunc("/survey", postSurvey).Methods("POST")
 	r.HandleFunc("/survey/{surveyRef}", getSurveyByRef).Methods("GET")
 	r.HandleFunc("/survey/{surveyRef}", deleteSurveyByRef).Methods("DELETE")
 	r.HandleFunc("/survey/{surveyRef}", updateSurveyByRef).Methods("PATCH")
+	r.HandleFunc("/survey/{surveyRef}/collectioninstrument", postInstrument).Methods("POST")
+	r.HandleFunc("/survey/{surveyRef}/collectionexercise/generate", generateSurveyExercises).Methods("POST")
+	r.HandleFunc("/collectionexercise", getExercises).Methods("GET")
+	r.HandleFunc("/collectionexercise", postExercise).Methods("POST")
+	r.HandleFunc("/collectionexercise/{uuid}", getExerciseByUUID).Methods("GET")
+	r.HandleFunc("/collectionexercise/{uuid}/timeline", getExerciseTimeline).Methods("GET")
+	r.HandleFunc("/collectionexercise/{uuid}/clone", postExerciseClone).Methods("POST")
+	r.HandleFunc("/collectioninstrument", getInstruments).Methods("GET")
+	r.HandleFunc("/collectioninstrument/{uuid}/file", getInstrumentFile).Methods("GET")
+}
+
+// handleAdminEndpoints routes the operational endpoints served on admin_port. They aren't authenticated, so that port
+// mustn't be exposed outside the pod.
+func handleAdminEndpoints(r *mux.Router) {
+	r.Use(traceRequests, logRequests, requestTimeout)
+	r.Handle("/admin/loglevel", logger.LevelHandler()).Methods("GET", "PUT")
+	r.HandleFunc("/admin/transitions", showPendingTransitions).Methods("GET")
 }
 
 func showInfo(w http.ResponseWriter, r *http.Request) {
-	serviceInfo := models.Info{Name: viper.GetString("service_name"), AppVersion: viper.GetString("app_version")}
+	serviceInfo := models.Info{
+		Name:      config.ServiceName,
+		Version:   appVersion(),
+		GitCommit: gitCommit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+		Features:  enabledFeatures(),
+	}
+	if db != nil {
+		version, dirty, err := schemaVersion(r.Context())
+		if err != nil {
+			logger.ForContext(r.Context()).Warn("Couldn't read the schema version for /info ", err)
+		} else {
+			serviceInfo.SchemaVersion = &version
+			serviceInfo.SchemaDirty = &dirty
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	json.NewEncoder(w).Encode(serviceInfo)
 }
 
@@ -36,435 +76,513 @@ func showHealth(w http.ResponseWriter, r *http.Request) {
 	// Rabbit data is dummy until implemented
 	dbStatus := "DOWN"
 	start := time.Now()
-	err := db.Ping()
+	err := db.PingContext(r.Context())
 	if err == nil {
 		latency := time.Since(start)
 		dbStatus = fmt.Sprintf("UP %s", latency.Truncate(time.Millisecond))
 	}
-	healthInfo := models.Health{Database: dbStatus, RabbitMQ: viper.GetString("dummy_health_rabbitmq")}
+	healthInfo := models.Health{Database: dbStatus, RabbitMQ: config.DummyHealthRabbitMQ}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	json.NewEncoder(w).Encode(healthInfo)
 }
 
-//Find survey by reference, short name, long name, or any combination of the three
+// Find survey by reference, short name, long name, or any combination of the three
 func getSurvey(w http.ResponseWriter, r *http.Request) {
 
-    if db == nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        errorString := models.Error{
-            Error: "Database connection could not be found",
-        }
-        json.NewEncoder(w).Encode(errorString)
-        return
-    }
-
-    queryParams := r.URL.Query()
-    if len(queryParams) == 0 {
-        w.WriteHeader(http.StatusBadRequest)
-        errorString := models.Error{
-            Error: "No query parameters provided for search",
-        }
-        json.NewEncoder(w).Encode(errorString)
-        return
-    }
-
-    var paramArray []string
-    var placeholderNumber int
-    var sb strings.Builder
-
-    sb.WriteString("SELECT id, survey_ref, short_name, long_name, legal_basis, survey_mode FROM " + viper.GetString("db_schema") + ".survey WHERE 1=1")
-
-    for params := range queryParams {
-        switch params {
-        case "surveyRef":
-            paramArray = append(paramArray, queryParams.Get("surveyRef"))
-            placeholderNumber = placeholderNumber + 1
-            sb.WriteString(" AND survey_ref = $" + strconv.Itoa(placeholderNumber))
-        case "shortName":
-            paramArray = append(paramArray, queryParams.Get("shortName"))
-            placeholderNumber = placeholderNumber + 1
-            sb.WriteString(" AND short_name = $" + strconv.Itoa(placeholderNumber))
-        case "longName":
-            paramArray = append(paramArray, queryParams.Get("longName"))
-            placeholderNumber = placeholderNumber + 1
-            sb.WriteString(" AND long_name = $" + strconv.Itoa(placeholderNumber))
-        default:
-            w.WriteHeader(http.StatusBadRequest)
-            errorString := models.Error{
-                Error: "Invalid query parameter " + params,
-            }
-            json.NewEncoder(w).Encode(errorString)
-            return
-        }
-    }
-
-    queryString := sb.String()
-    var rows *sql.Rows
-    var err error
-
-    if len(paramArray) == 1 {
-        rows, err = db.Query(queryString, paramArray[0])
-    } else if len(paramArray) == 2 {
-        rows, err = db.Query(queryString, paramArray[0], paramArray[1])
-    } else {
-        rows, err = db.Query(queryString, paramArray[0], paramArray[1], paramArray[2])
-    }
-
-    if err != nil {
-        http.Error(w, "get survey query failed", http.StatusInternalServerError)
-        return
-    }
-
-    var listOfSurveys = []models.Survey{}
-
-    for rows.Next(){
-        survey := models.Survey{}
-
-        err = rows.Scan(
-            &survey.ID,
-            &survey.SurveyRef,
-            &survey.ShortName,
-            &survey.LongName,
-            &survey.LegalBasis,
-            &survey.SurveyMode,
-        )
-        if err != nil {
-            http.Error(w, "Error scanning database rows", http.StatusInternalServerError)
-            return
-        }
-
-        listOfSurveys = append(listOfSurveys, survey)
-    }
-
-    if len(listOfSurveys) == 0 {
-        w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-        w.WriteHeader(http.StatusNotFound)
-
-        return
-    }
-
-    data, err := json.Marshal(listOfSurveys)
-    if err != nil {
-        http.Error(w, "Failed to marshal survey JSON", http.StatusInternalServerError)
-        return
-    }
-
-    logger.Logger.Info("Successfully retrieved survey")
-    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-    w.WriteHeader(http.StatusOK)
-    w.Write(data)
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+
+	queryParams := r.URL.Query()
+
+	filters := map[string]string{}
+	for params := range queryParams {
+		if params == "include" {
+			continue
+		}
+		if _, ok := surveySearchColumns[params]; !ok {
+			writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid query parameter "+params)
+			return
+		}
+		filters[params] = queryParams.Get(params)
+	}
+	if len(filters) == 0 {
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "No query parameters provided for search")
+		return
+	}
+
+	listOfSurveys, err := searchSurveys(r, filters)
+	if err != nil {
+		writeDBError(w, r, err)
+		return
+	}
+
+	if len(listOfSurveys) == 0 {
+		writeRESTError(w, r, http.StatusNotFound, models.CodeSurveyNotFound, "No surveys match the search")
+		return
+	}
+
+	data, err := json.Marshal(models.Surveys{Data: listOfSurveys})
+	if err != nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeInternalError, "Failed to marshal survey JSON")
+		return
+	}
+
+	logger.ForContext(r.Context()).Info("Successfully retrieved survey")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// searchSurveys finds the surveys matching filters, with their instruments if the request has include=instruments
+func searchSurveys(r *http.Request, filters map[string]string) ([]models.SurveyWithInstruments, error) {
+	if r.URL.Query().Get("include") == "instruments" {
+		return findSurveysWithInstruments(r.Context(), filters)
+	}
+	surveys, err := findSurveys(r.Context(), filters)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]models.SurveyWithInstruments, len(surveys))
+	for i, survey := range surveys {
+		results[i].Survey = survey
+	}
+	return results, nil
 }
 
 //Create survey based on JSON request
-func postSurvey (w http.ResponseWriter, r *http.Request) {
-
-    if db == nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        errorString := models.Error{
-            Error: "Database connection could not be found",
-        }
-        json.NewEncoder(w).Encode(errorString)
-        return
-    }
-
-    body, err := ioutil.ReadAll(r.Body)
-    if err != nil {
-        http.Error(w, "Couldn't read message body", http.StatusInternalServerError)
-        return
-    }
-
-    var survey models.Survey
-    err = json.Unmarshal(body, &survey)
-    if err != nil {
-        http.Error(w, "Error unmarshalling JSON", http.StatusBadRequest)
-        return
-    }
-
-    tx, err := db.Begin()
-    if err != nil {
-        http.Error(w, "Error starting database transaction", http.StatusInternalServerError)
-        return
-    }
-    defer tx.Rollback()
-
-    stmt, err := db.Prepare("INSERT INTO " + viper.GetString("db_schema") + ".survey VALUES($1, $2, $3, $4, $5, $6)")
-    if err != nil {
-        http.Error(w, "SQL statement not prepared", http.StatusInternalServerError)
-        return
-    }
-
-    defer stmt.Close()
-
-    // Generate a UUID to uniquely identify the new survey
-    newID, err := uuid.NewV4()
-    if err != nil {
-        http.Error(w, "Error generating random uuid", http.StatusInternalServerError)
-        return
-    }
-
-    survey.ID = newID.String()
-
-    _, err = stmt.Exec(survey.ID, survey.SurveyRef, survey.ShortName, survey.LongName, survey.LegalBasis, survey.SurveyMode)
-    if err != nil {
-        http.Error(w, "SQL statement error" + err.Error(), http.StatusInternalServerError)
-        return
-    }
-
-    err = tx.Commit()
-    if err != nil {
-            http.Error(w, "Error committing database transaction", http.StatusInternalServerError)
-            return
-        }
-
-    var js []byte
-    js, err = json.Marshal(&survey)
-
-    logger.Logger.Info("Successfully posted survey")
-    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-    w.WriteHeader(http.StatusCreated)
-    w.Write(js)
+func postSurvey(w http.ResponseWriter, r *http.Request) {
+
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeInternalError, "Couldn't read message body")
+		return
+	}
+
+	var survey models.Survey
+	err = json.Unmarshal(body, &survey)
+	if err != nil {
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidJSON, "Error unmarshalling JSON")
+		return
+	}
+
+	err = createSurvey(r.Context(), &survey)
+	if err != nil {
+		writeDBError(w, r, err)
+		return
+	}
+
+	var js []byte
+	js, err = json.Marshal(&survey)
+
+	logger.ForContext(r.Context()).Info("Successfully posted survey")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(js)
 }
 
 //Get survey using the parameter reference
-func getSurveyByRef (w http.ResponseWriter, r *http.Request) {
+func getSurveyByRef(w http.ResponseWriter, r *http.Request) {
 
-    if db == nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        errorString := models.Error{
-            Error: "Database connection could not be found",
-        }
-        json.NewEncoder(w).Encode(errorString)
-        return
-    }
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
 
-    vars := mux.Vars(r)
+	vars := mux.Vars(r)
 
-    surveyRef := vars["surveyRef"]
+	listOfSurveys, err := searchSurveys(r, map[string]string{"reference": vars["surveyRef"]})
+	if err != nil {
+		writeDBError(w, r, err)
+		return
+	}
 
-    queryString := "SELECT id, survey_ref, short_name, long_name, legal_basis, survey_mode FROM " + viper.GetString("db_schema") + ".survey WHERE survey_ref = $1"
+	if len(listOfSurveys) == 0 {
+		writeRESTError(w, r, http.StatusNotFound, models.CodeSurveyNotFound, "Survey reference not found")
+		return
+	}
 
-    rows, err := db.Query(queryString, surveyRef)
+	// The survey is returned on its own, unless its instruments were asked for
+	var survey interface{} = listOfSurveys[0].Survey
+	if r.URL.Query().Get("include") == "instruments" {
+		survey = listOfSurveys[0]
+	}
+	data, err := json.Marshal(survey)
+	if err != nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeInternalError, "Failed to marshal survey JSON")
+		return
+	}
 
-    if err != nil {
-        http.Error(w, "get survey query failed", http.StatusInternalServerError)
-        return
-    }
+	logger.ForContext(r.Context()).Info("Successfully retrieved survey from reference")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
 
-    var listOfSurveys = []models.Survey{}
+}
 
-    for rows.Next(){
-        survey := models.Survey{}
+//Delete survey based on given reference
+func deleteSurveyByRef(w http.ResponseWriter, r *http.Request) {
 
-        err = rows.Scan(
-            &survey.ID,
-            &survey.SurveyRef,
-            &survey.ShortName,
-            &survey.LongName,
-            &survey.LegalBasis,
-            &survey.SurveyMode,
-        )
-        if err != nil {
-                http.Error(w, "Error scanning database rows", http.StatusInternalServerError)
-                return
-            }
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
 
+	var params = mux.Vars(r)
 
-        listOfSurveys = append(listOfSurveys, survey)
-    }
+	err := deleteSurvey(r.Context(), params["surveyRef"])
+	if err != nil {
+		if err == errSurveyNotFound {
+			writeRESTError(w, r, http.StatusNotFound, models.CodeSurveyNotFound, "Survey reference not found")
+			return
+		}
+		writeDBError(w, r, err)
+		return
+	}
 
-    if len(listOfSurveys) == 0 {
-        w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-        w.WriteHeader(http.StatusNotFound)
+	logger.ForContext(r.Context()).Info("Successfully deleted survey")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusNoContent)
+}
 
-        return
-    }
+//Update survey based on JSON request
+func updateSurveyByRef(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
 
-    data, err := json.Marshal(listOfSurveys)
-    if err != nil {
-        http.Error(w, "Failed to marshal survey JSON", http.StatusInternalServerError)
-        return
-    }
+	var params = mux.Vars(r)
 
-    logger.Logger.Info("Successfully retrieved survey from reference")
-    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-    w.WriteHeader(http.StatusOK)
-    w.Write(data)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeInternalError, "Couldn't read message body")
+		return
+	}
+
+	var survey models.Survey
+
+	err = json.Unmarshal(body, &survey)
+	if err != nil {
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidJSON, "Error unmarshalling JSON")
+		return
+	}
+
+	if survey.ShortName == "" && survey.LongName == "" && survey.LegalBasis == "" && survey.SurveyMode == "" {
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeNoValuesToUpdate, "No values to update")
+		return
+	}
+
+	survey, err = updateSurvey(r.Context(), params["surveyRef"], survey)
+	if err != nil {
+		if err == errSurveyNotFound {
+			writeRESTError(w, r, http.StatusNotFound, models.CodeSurveyNotFound, "Survey reference not found")
+			return
+		}
+		writeDBError(w, r, err)
+		return
+	}
 
+	var js []byte
+	js, err = json.Marshal(&survey)
+
+	logger.ForContext(r.Context()).Info("Successfully updated survey")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(js)
 }
 
-//Delete survey based on given reference
-func deleteSurveyByRef (w http.ResponseWriter, r *http.Request) {
-
-    if db == nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        errorString := models.Error{
-            Error: "Database connection could not be found",
-        }
-        json.NewEncoder(w).Encode(errorString)
-        return
-    }
-
-    var params = mux.Vars(r)
-
-    tx, err := db.Begin()
-    if err != nil {
-        http.Error(w, "Error starting database transaction", http.StatusInternalServerError)
-        return
-    }
-    defer tx.Rollback()
-
-    results := db.QueryRow("SELECT * FROM " + viper.GetString("db_schema") + ".survey WHERE survey_ref = $1" , params["surveyRef"])
-    var tempSurvey models.Survey
-    err = results.Scan(&tempSurvey.ID, &tempSurvey.SurveyRef, &tempSurvey.ShortName, &tempSurvey.LongName, &tempSurvey.LegalBasis, &tempSurvey.SurveyMode)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            http.Error(w, "Survey reference not found", http.StatusNotFound)
-            return
-        }
-        http.Error(w, "Check query failed", http.StatusInternalServerError)
-        return
-    }
-
-    stmt, err := db.Prepare("DELETE FROM " + viper.GetString("db_schema") + ".survey WHERE survey_ref = $1")
-    if err != nil {
-        http.Error(w, "SQL statement not prepared", http.StatusInternalServerError)
-        return
-    }
-
-    defer stmt.Close()
-
-    _, err = stmt.Exec(params["surveyRef"])
-    if err != nil {
-        http.Error(w, "SQL statement error" + err.Error(), http.StatusInternalServerError)
-        return
-    }
-
-    err = tx.Commit()
-    if err != nil {
-        http.Error(w, "Error committing database transaction", http.StatusInternalServerError)
-        return
-    }
-
-    logger.Logger.Info("Successfully deleted survey")
-    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-    w.WriteHeader(http.StatusNoContent)
+// Find collection exercises by survey reference, state or both, with their surveys and instruments if verbose=true
+func getExercises(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+
+	queryParams := r.URL.Query()
+	for params := range queryParams {
+		switch params {
+		case "reference", "state", "verbose":
+		default:
+			writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid query parameter "+params)
+			return
+		}
+	}
+	filter := exerciseFilter{surveyRef: queryParams.Get("reference"), state: queryParams.Get("state")}
+
+	var body interface{}
+	var found int
+	if verbose(r) {
+		details, err := findExerciseDetails(r.Context(), filter)
+		if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+		body, found = models.CollectionExerciseDetails{Data: details}, len(details)
+	} else {
+		exercises, err := findExercises(r.Context(), filter)
+		if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+		body, found = models.CollectionExercises{Data: exercises}, len(exercises)
+	}
+
+	if found == 0 {
+		writeRESTError(w, r, http.StatusNotFound, models.CodeExerciseNotFound, "No collection exercises match the search")
+		return
+	}
+
+	logger.ForContext(r.Context()).Info("Successfully retrieved collection exercises")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(body)
 }
 
-//Update survey based on JSON request
-func updateSurveyByRef (w http.ResponseWriter, r *http.Request) {
-    if db == nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        errorString := models.Error{
-            Error: "Database connection could not be found",
-        }
-        json.NewEncoder(w).Encode(errorString)
-        return
-    }
-
-    var params = mux.Vars(r)
-
-    body, err := ioutil.ReadAll(r.Body)
-    if err != nil {
-        http.Error(w, "Couldn't read message body", http.StatusInternalServerError)
-        return
-    }
-
-    var survey models.Survey
-
-    err = json.Unmarshal(body, &survey)
-    if err != nil {
-        http.Error(w, "Error unmarshalling JSON", http.StatusBadRequest)
-        return
-    }
-
-    if survey.ShortName == "" && survey.LongName == "" && survey.LegalBasis == "" && survey.SurveyMode == "" {
-        http.Error(w, "No values to update", http.StatusBadRequest)
-        return
-    }
-
-    tx, err := db.Begin()
-    if err != nil {
-        http.Error(w, "Error starting database transaction", http.StatusInternalServerError)
-        return
-    }
-    defer tx.Rollback()
-
-    selectQuery := "SELECT * FROM " + viper.GetString("db_schema") + ".survey WHERE survey_ref = $1"
-
-    results := db.QueryRow(selectQuery, params["surveyRef"])
-    var tempSurvey models.Survey
-    err = results.Scan(&tempSurvey.ID, &tempSurvey.SurveyRef, &tempSurvey.ShortName, &tempSurvey.LongName, &tempSurvey.LegalBasis, &tempSurvey.SurveyMode)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            http.Error(w, "Survey reference not found", http.StatusNotFound)
-            return
-        }
-        http.Error(w, "Check query failed", http.StatusInternalServerError)
-        return
-    }
-
-    newShortName := "short_name"
-    newLongName := "long_name"
-    newLegalBasis := "legal_basis"
-    newSurveyMode := "survey_mode"
-
-    if survey.ShortName != "" {
-        newShortName = survey.ShortName
-    } else {
-        newShortName = tempSurvey.ShortName
-    }
-    if survey.LongName != "" {
-        newLongName = survey.LongName
-    } else {
-        newLongName = tempSurvey.LongName
-    }
-    if survey.LegalBasis != "" {
-        newLegalBasis = survey.LegalBasis
-    } else {
-        newLegalBasis = tempSurvey.LegalBasis
-    }
-    if survey.SurveyMode != "" {
-        newSurveyMode = survey.SurveyMode
-    } else {
-        newSurveyMode = tempSurvey.SurveyMode
-    }
-
-    updateQuery := "UPDATE " + viper.GetString("db_schema") + ".survey SET short_name = $1, long_name = $2, legal_basis = $3, survey_mode = $4 WHERE survey_ref = $5"
-
-    stmt, err := db.Prepare(updateQuery)
-    if err != nil {
-        http.Error(w, "SQL statement not prepared", http.StatusInternalServerError)
-        return
-    }
-
-    defer stmt.Close()
-
-    survey.SurveyRef = params["surveyRef"]
-
-    _, err = stmt.Exec(newShortName, newLongName, newLegalBasis, newSurveyMode, survey.SurveyRef)
-    if err != nil {
-        http.Error(w, "SQL statement error" + err.Error(), http.StatusInternalServerError)
-        return
-    }
-
-    err = tx.Commit()
-    if err != nil {
-            http.Error(w, "Error committing database transaction", http.StatusInternalServerError)
-            return
-        }
-
-    //redo the select query to show what the survey looks like now
-    newResults := db.QueryRow(selectQuery, survey.SurveyRef)
-    err = newResults.Scan(&survey.ID, &survey.SurveyRef, &survey.ShortName, &survey.LongName, &survey.LegalBasis, &survey.SurveyMode)
-        if err != nil {
-            http.Error(w, "Second search query failed", http.StatusInternalServerError)
-            return
-        }
-
-    var js []byte
-    js, err = json.Marshal(&survey)
-
-    logger.Logger.Info("Successfully updated survey")
-    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-    w.WriteHeader(http.StatusOK)
-    w.Write(js)
-}
\ No newline at end of file
+// Get a collection exercise by UUID, with its survey and instruments if verbose=true
+func getExerciseByUUID(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+
+	filter := exerciseFilter{exerciseUUID: mux.Vars(r)["uuid"]}
+
+	var body interface{}
+	if verbose(r) {
+		details, err := findExerciseDetails(r.Context(), filter)
+		if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+		if len(details) > 0 {
+			body = details[0]
+		}
+	} else {
+		exercises, err := findExercises(r.Context(), filter)
+		if err != nil {
+			writeDBError(w, r, err)
+			return
+		}
+		if len(exercises) > 0 {
+			body = exercises[0]
+		}
+	}
+
+	if body == nil {
+		writeRESTError(w, r, http.StatusNotFound, models.CodeExerciseNotFound, "Collection exercise not found")
+		return
+	}
+
+	logger.ForContext(r.Context()).Info("Successfully retrieved collection exercise")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(body)
+}
+
+// Create a collection exercise, in the CREATED state, for an existing survey. Its dates must follow the survey's rules.
+func postExercise(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+
+	var exercise models.CollectionExercise
+	if err := json.NewDecoder(r.Body).Decode(&exercise); err != nil {
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidJSON, "Error unmarshalling JSON")
+		return
+	}
+	exercise.State = "CREATED"
+
+	if problems := checkExerciseDates(exercise); len(problems) > 0 {
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidExerciseDates, (&exerciseDatesError{problems: problems}).Error())
+		return
+	}
+
+	if err := createExercise(r.Context(), &exercise); err != nil {
+		var exists *exerciseExistsError
+		if errors.As(err, &exists) {
+			writeExerciseExists(w, r, exists.exerciseUUID)
+			return
+		}
+		if err == errSurveyNotFound {
+			writeRESTError(w, r, http.StatusNotFound, models.CodeSurveyNotFound, "Survey reference not found")
+			return
+		}
+		writeDBError(w, r, err)
+		return
+	}
+
+	logger.ForContext(r.Context()).Info("Successfully posted collection exercise")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(exercise)
+}
+
+// Create the collection exercises a survey's schedule gives for the periods starting between from and to, skipping
+// periods the survey already has
+func generateSurveyExercises(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+
+	surveyRef := mux.Vars(r)["surveyRef"]
+	schedule, ok := scheduleFor(surveyRef)
+	if !ok {
+		writeRESTError(w, r, http.StatusUnprocessableEntity, models.CodeNoExerciseSchedule, "Survey "+surveyRef+" has no exercise schedule")
+		return
+	}
+
+	var dates [2]time.Time
+	for i, param := range []string{"from", "to"} {
+		var err error
+		if dates[i], err = time.Parse("2006-01-02", r.URL.Query().Get(param)); err != nil {
+			writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidParameter, param+" must be a date, e.g. 2020-01-01")
+			return
+		}
+	}
+	exercises := schedule.exercises(surveyRef, dates[0], dates[1])
+	if len(exercises) > maxGeneratedPeriods {
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidParameter, fmt.Sprintf("Can't generate more than %d periods at once", maxGeneratedPeriods))
+		return
+	}
+
+	// The survey's date rules can be changed after its schedule is written
+	for _, exercise := range exercises {
+		if problems := checkExerciseDates(exercise); len(problems) > 0 {
+			writeRESTError(w, r, http.StatusUnprocessableEntity, models.CodeInvalidExerciseDates,
+				"The exercise schedule gives invalid dates for period "+exercise.PeriodName+": "+strings.Join(problems, "; "))
+			return
+		}
+	}
+
+	generated, err := generateExercises(r.Context(), surveyRef, exercises)
+	if err == errSurveyNotFound {
+		writeRESTError(w, r, http.StatusNotFound, models.CodeSurveyNotFound, "Survey not found")
+		return
+	}
+	if err != nil {
+		writeDBError(w, r, err)
+		return
+	}
+
+	logger.ForContext(r.Context()).Infow("Generated collection exercises", "survey_ref", surveyRef,
+		"created", len(generated.Created), "skipped", len(generated.Skipped))
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if len(generated.Created) > 0 {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(generated)
+}
+
+// Copy a collection exercise to a new period, with its dates and emails moved on and the same instruments
+func postExerciseClone(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+
+	var clone models.CloneExercise
+	if err := json.NewDecoder(r.Body).Decode(&clone); err != nil {
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidJSON, "Error unmarshalling JSON")
+		return
+	}
+
+	exercise, err := cloneExercise(r.Context(), mux.Vars(r)["uuid"], clone)
+	if err != nil {
+		var exists *exerciseExistsError
+		var dates *exerciseDatesError
+		switch {
+		case errors.As(err, &exists):
+			writeExerciseExists(w, r, exists.exerciseUUID)
+		case errors.As(err, &dates):
+			writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidExerciseDates, dates.Error())
+		case err == errExerciseNotFound:
+			writeRESTError(w, r, http.StatusNotFound, models.CodeExerciseNotFound, "Collection exercise not found")
+		default:
+			writeDBError(w, r, err)
+		}
+		return
+	}
+
+	logger.ForContext(r.Context()).Infow("Successfully cloned collection exercise", "exercise_uuid", exercise.ExerciseUUID)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(exercise)
+}
+
+// Search collection instruments by survey, type and classifiers, given as classifier.<name>, e.g. classifier.formType
+func getInstruments(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+
+	queryParams := r.URL.Query()
+	filter := instrumentFilter{surveyRef: queryParams.Get("survey"), instrumentType: queryParams.Get("type"), classifiers: map[string]string{}}
+	for param := range queryParams {
+		switch {
+		case param == "survey", param == "type":
+		case strings.HasPrefix(param, "classifier.") && len(param) > len("classifier."):
+			filter.classifiers[strings.TrimPrefix(param, "classifier.")] = queryParams.Get(param)
+		default:
+			writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid query parameter "+param)
+			return
+		}
+	}
+
+	instruments, err := findInstruments(r.Context(), filter)
+	if err != nil {
+		writeDBError(w, r, err)
+		return
+	}
+	if len(instruments) == 0 {
+		writeRESTError(w, r, http.StatusNotFound, models.CodeInstrumentNotFound, "No collection instruments match the search")
+		return
+	}
+
+	logger.ForContext(r.Context()).Info("Successfully retrieved collection instruments")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(models.CollectionInstruments{Data: instruments})
+}
+
+// Get a collection exercise's dates and scheduled emails as one list of events in time order
+func getExerciseTimeline(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+
+	exerciseUUID := mux.Vars(r)["uuid"]
+	exercises, err := findExercises(r.Context(), exerciseFilter{exerciseUUID: exerciseUUID})
+	if err != nil {
+		writeDBError(w, r, err)
+		return
+	}
+	if len(exercises) == 0 {
+		writeRESTError(w, r, http.StatusNotFound, models.CodeExerciseNotFound, "Collection exercise not found")
+		return
+	}
+
+	emails, err := findEmailEvents(r.Context(), exerciseUUID)
+	if err != nil {
+		writeDBError(w, r, err)
+		return
+	}
+
+	logger.ForContext(r.Context()).Info("Successfully retrieved collection exercise timeline")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(exerciseTimeline(exercises[0], emails))
+}
+
+// verbose reports whether the request asked for related entities to be included. The value has already been checked
+// against openapi.yaml, so anything that doesn't parse is treated as false.
+func verbose(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("verbose"))
+	return v
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
 	"testing"
 	"time"
 	"bytes"
@@ -13,7 +15,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/ONSdigital/ras-rm-survey/models"
 	"github.com/gorilla/mux"
-	"github.com/spf13/viper"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	// Yes, this import is weird but the mySQL driver offers passing a mock sql.DB and the postgres one doesn't.
 )
@@ -29,12 +31,32 @@ var deleteSurveyExec = "DELETE FROM (.+)*"
 var updateSurveyExec = "UPDATE (.+)*"
 
 func setup() {
-	setDefaults()
+	config = defaultConfig()
 	router = mux.NewRouter()
 	resp = httptest.NewRecorder()
+	// Fail any test whose response breaks the contract in openapi.yaml
+	config.OpenAPIValidateResponses = true
 	handleEndpoints(router)
 }
 
+// adminRouter routes the endpoints served on admin_port
+func adminRouter() *mux.Router {
+	r := mux.NewRouter()
+	handleAdminEndpoints(r)
+	return r
+}
+
+func TestAdminEndpointsArentServedOnThePublicPort(t *testing.T) {
+	for _, path := range []string{"/admin/loglevel", "/admin/transitions"} {
+		setup()
+
+		req := httptest.NewRequest("GET", path, nil)
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNotFound, resp.Code, path)
+	}
+}
+
 func TestInfoEndpoint(t *testing.T) {
 	setup()
 
@@ -51,8 +73,55 @@ func TestInfoEndpoint(t *testing.T) {
 		t.Fatal("Error decoding JSON response from 'GET /info', ", err.Error())
 	}
 
-	assert.Equal(t, viper.GetString("service_name"), info.Name)
-	assert.Equal(t, viper.GetString("app_version"), info.AppVersion)
+	assert.Equal(t, config.ServiceName, info.Name)
+	assert.Equal(t, appVersion(), info.Version)
+	assert.Equal(t, gitCommit, info.GitCommit)
+	assert.Equal(t, buildTime, info.BuildTime)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+	assert.Equal(t, []string{"auto-migrate", "exercise-transitions", "log-sampling"}, info.Features)
+}
+
+func TestInfoEndpointPrefersTheBuiltVersion(t *testing.T) {
+	setup()
+	config.AppVersion = "0.0.1"
+	defer func(built string) { version = built }(version)
+	version = "1.2.3"
+
+	req := httptest.NewRequest("GET", "/info", nil)
+	router.ServeHTTP(resp, req)
+
+	var info models.Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /info', ", err.Error())
+	}
+	assert.Equal(t, "1.2.3", info.Version)
+}
+
+func TestInfoEndpointReportsSchemaVersion(t *testing.T) {
+	setup()
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectQuery("SELECT version, dirty FROM schema_migrations").WillReturnRows(mock.NewRows([]string{"version", "dirty"}).AddRow(1, false))
+
+	req := httptest.NewRequest("GET", "/info", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var info models.Info
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /info', ", err.Error())
+	}
+
+	assert.Equal(t, uint(1), *info.SchemaVersion)
+	assert.False(t, *info.SchemaDirty)
 }
 
 func TestHealthEndpoint(t *testing.T) {
@@ -78,7 +147,7 @@ func TestHealthEndpoint(t *testing.T) {
 	}
 
 	assert.Equal(t, "UP 100ms", health.Database)
-	assert.Equal(t, viper.GetString("dummy_health_rabbitmq"), health.RabbitMQ)
+	assert.Equal(t, config.DummyHealthRabbitMQ, health.RabbitMQ)
 }
 
 func TestGetSurveyEndpoint (t *testing.T) {
@@ -94,14 +163,14 @@ func TestGetSurveyEndpoint (t *testing.T) {
 
     returnRows := mock.NewRows(searchSurveyQueryColumns)
 
-    returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "Test Survey Mode")
+    returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "SEFT")
 
     mock.ExpectQuery(findSurveyQuery).WillReturnRows(returnRows)
 
     req := httptest.NewRequest("GET", "/survey?shortName=TS", nil)
     router.ServeHTTP(resp, req)
 
-    var surveys []models.Survey
+    var surveys models.Surveys
 
     err = json.NewDecoder(resp.Body).Decode(&surveys)
     if err != nil {
@@ -109,7 +178,7 @@ func TestGetSurveyEndpoint (t *testing.T) {
     }
 
     assert.Equal(t, http.StatusOK, resp.Code)
-    assert.Equal(t, surveys[0].SurveyRef, "123")
+    assert.Equal(t, surveys.Data[0].Survey.SurveyRef, "123")
 
 }
 
@@ -125,7 +194,7 @@ func TestPostSurveyEndpoint (t *testing.T) {
         t.Fatal("Error setting up an SQL mock" + err.Error())
     }
 
-    var jsonStr = []byte(`{"surveyRef":"156","shortName":"NEWPOST3333","longName":"postsurvey","legalBasis":"Ltest2","surveyMode":"aTEST2"}`)
+    var jsonStr = []byte(`{"reference":"156","shortName":"NEWPOST3333","longName":"postsurvey","legalBasis":"Ltest2","surveyMode":"EQ"}`)
 
     mock.ExpectBegin()
     mock.ExpectPrepare(postSurveyExec).ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
@@ -159,22 +228,22 @@ func TestGetSurveyByRefEndpoint(t *testing.T) {
 
     returnRows := mock.NewRows(searchSurveyQueryColumns)
 
-    returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "Test Survey Mode")
+    returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "SEFT")
 
     mock.ExpectQuery(findSurveyQuery).WillReturnRows(returnRows)
 
     req := httptest.NewRequest("GET", "/survey/123", nil)
     router.ServeHTTP(resp, req)
 
-    var surveys []models.Survey
+    var survey models.Survey
 
-    err = json.NewDecoder(resp.Body).Decode(&surveys)
+    err = json.NewDecoder(resp.Body).Decode(&survey)
     if err != nil {
         t.Fatal("Error decoding JSON response from 'GET /survey', ", err.Error())
     }
 
     assert.Equal(t, http.StatusOK, resp.Code)
-    assert.Equal(t, surveys[0].SurveyRef, "123")
+    assert.Equal(t, survey.SurveyRef, "123")
 }
 
 func TestDeleteSurveyEndpoint (t *testing.T) {
@@ -218,9 +287,9 @@ func TestUpdateSurveyEndpoint (t *testing.T) {
     beforePatchReturnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "Test Survey Mode")
 
     afterPatchReturnRows := mock.NewRows(searchSurveyQueryColumns)
-    afterPatchReturnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "NEWPOST3333", "postsurvey", "Ltest2", "SMtest2")
+    afterPatchReturnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "NEWPOST3333", "postsurvey", "Ltest2", "EQ")
 
-    var jsonStr = []byte(`{"shortName":"NEWPOST3333","longName":"postsurvey","legalBasis":"Ltest2", "surveyMode":"SMtest2"}`)
+    var jsonStr = []byte(`{"shortName":"NEWPOST3333","longName":"postsurvey","legalBasis":"Ltest2", "surveyMode":"EQ"}`)
 
     mock.ExpectBegin()
     mock.ExpectQuery(findSurveyQuery).WillReturnRows(beforePatchReturnRows)
@@ -258,7 +327,7 @@ func TestUpdateSurveyEndpointReturns200WhenNotAllParametersFilledIn (t *testing.
     beforePatchReturnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "Test Survey Mode")
 
     afterPatchReturnRows := mock.NewRows(searchSurveyQueryColumns)
-    afterPatchReturnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "NEWPOST3333", "postsurvey", "Ltest2", "Test Survey Mode")
+    afterPatchReturnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "NEWPOST3333", "postsurvey", "Ltest2", "SEFT")
 
     var jsonStr = []byte(`{"shortName":"NEWPOST3333","longName":"postsurvey","legalBasis":"Ltest2"}`)
 
@@ -281,7 +350,7 @@ func TestUpdateSurveyEndpointReturns200WhenNotAllParametersFilledIn (t *testing.
     }
 
     assert.Equal(t, survey.ShortName, "NEWPOST3333")
-    assert.Equal(t, survey.SurveyMode, "Test Survey Mode")
+    assert.Equal(t, survey.SurveyMode, "SEFT")
 }
 
 func TestDeleteSurveyEndpointReturns404WhenSurveyRefNotFound (t *testing.T) {
@@ -323,7 +392,7 @@ func TestUpdateSurveyEndpointReturns404WhenSurveyRefNotFound (t *testing.T) {
 
     returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "Test Survey Mode")
 
-    var jsonStr = []byte(`{"shortName":"NEWPOST3333","longName":"postsurvey","legalBasis":"Ltest2","surveyMode":"aTEST2"}`)
+    var jsonStr = []byte(`{"shortName":"NEWPOST3333","longName":"postsurvey","legalBasis":"Ltest2","surveyMode":"EQ"}`)
 
     mock.ExpectBegin()
     mock.ExpectQuery(findSurveyQuery).WillReturnError(sql.ErrNoRows)
@@ -466,4 +535,912 @@ func TestUpdateSurveyEndpointReturns400WhenNoNewValues (t *testing.T) {
     router.ServeHTTP(resp, req)
 
     assert.Equal(t, http.StatusBadRequest, resp.Code)
-}
\ No newline at end of file
+}
+func TestGetSurveyEndpointReturns504WhenQueryTimesOut(t *testing.T) {
+	setup()
+	config.HTTP.RequestTimeout = 20 * time.Millisecond
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	returnRows := mock.NewRows(searchSurveyQueryColumns)
+	returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "Test Survey Mode")
+
+	mock.ExpectQuery(findSurveyQuery).WillDelayFor(time.Second).WillReturnRows(returnRows)
+
+	start := time.Now()
+	req := httptest.NewRequest("GET", "/survey?shortName=TS", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, resp.Code)
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond), "query should be cancelled at the deadline")
+
+	var restError models.RESTError
+	err = json.NewDecoder(resp.Body).Decode(&restError)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /survey', ", err.Error())
+	}
+	assert.Equal(t, "REQUEST_TIMEOUT", restError.Code)
+}
+
+func TestUpdateSurveyEndpointReturns504WhenUpdateTimesOut(t *testing.T) {
+	setup()
+	config.HTTP.RequestTimeout = 20 * time.Millisecond
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	beforePatchReturnRows := mock.NewRows(searchSurveyQueryColumns)
+	beforePatchReturnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "Test Survey Mode")
+
+	var jsonStr = []byte(`{"shortName":"NEWPOST3333"}`)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(findSurveyQuery).WillReturnRows(beforePatchReturnRows)
+	mock.ExpectPrepare(updateSurveyExec).ExpectExec().WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := httptest.NewRequest("PATCH", "/survey/123", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, resp.Code)
+}
+
+func TestGetSurveyEndpointReturns503WhenClientGoesAway(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectQuery(findSurveyQuery).WillDelayFor(time.Second).WillReturnRows(mock.NewRows(searchSurveyQueryColumns))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	req := httptest.NewRequest("GET", "/survey?shortName=TS", nil).WithContext(ctx)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+}
+
+var exerciseColumnNames = []string{"exercise_uuid", "survey_ref", "state", "period_name", "mps", "go_live", "period_start", "period_end", "employment", "return"}
+var exerciseDetailColumnNames = append(append([]string{}, exerciseColumnNames...), "id", "survey_ref", "short_name", "long_name", "legal_basis", "survey_mode", "instrument_uuid", "type", "classifiers", "seft_filename")
+
+var findExercisesQuery = "SELECT (.+) FROM surveyv2.collection_exercise ce WHERE 1=1 AND ce.survey_ref = \\$1 ORDER BY ce.exercise_id"
+var findExerciseDetailsQuery = "SELECT (.+) FROM surveyv2.collection_exercise ce JOIN surveyv2.survey s (.+) LEFT JOIN surveyv2.associated_instruments ai (.+) LEFT JOIN surveyv2.collection_instrument ci (.+)"
+
+func TestGetExercisesEndpoint(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	goLive := time.Date(2020, 9, 1, 9, 0, 0, 0, time.UTC)
+	returnRows := mock.NewRows(exerciseColumnNames)
+	returnRows.AddRow("6f1bf642-2f9c-408f-8ffe-93b40667d99a", "141", "LIVE", "202009", nil, goLive, nil, nil, nil, nil)
+	returnRows.AddRow("0bba3b39-2a41-4b5b-8e1a-ffd9c14c2ef1", "141", "", "202010", nil, nil, nil, nil, nil, nil)
+
+	mock.ExpectQuery(findExercisesQuery).WithArgs("141").WillReturnRows(returnRows)
+
+	req := httptest.NewRequest("GET", "/collectionexercise?reference=141", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var exercises models.CollectionExercises
+	err = json.NewDecoder(resp.Body).Decode(&exercises)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /collectionexercise', ", err.Error())
+	}
+	assert.Len(t, exercises.Data, 2)
+	assert.Equal(t, "LIVE", exercises.Data[0].State)
+	assert.True(t, goLive.Equal(*exercises.Data[0].GoLive))
+	assert.Nil(t, exercises.Data[0].MPS)
+	assert.Equal(t, "202010", exercises.Data[1].PeriodName)
+}
+
+func TestGetExercisesEndpointVerboseUsesOneQuery(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	returnRows := mock.NewRows(exerciseDetailColumnNames)
+	returnRows.AddRow("6f1bf642-2f9c-408f-8ffe-93b40667d99a", "141", "LIVE", "202009", nil, nil, nil, nil, nil, nil,
+		"8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT",
+		"ddc37cb6-c88a-473b-949a-fa5fad9265a1", "SEFT", []byte(`{"formType":"0001"}`), "seft_instrument.xls")
+	returnRows.AddRow("6f1bf642-2f9c-408f-8ffe-93b40667d99a", "141", "LIVE", "202009", nil, nil, nil, nil, nil, nil,
+		"8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT",
+		"3f1f9a4c-5b1e-4c8e-9f0d-2b8a3c5d7e9f", "SEFT", []byte(`{"formType":"0002"}`), "seft_instrument_2.xls")
+	returnRows.AddRow("0bba3b39-2a41-4b5b-8e1a-ffd9c14c2ef1", "141", "CREATED", "202010", nil, nil, nil, nil, nil, nil,
+		"8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT",
+		nil, nil, nil, nil)
+
+	mock.ExpectQuery(findExerciseDetailsQuery).WithArgs("141").WillReturnRows(returnRows)
+
+	req := httptest.NewRequest("GET", "/collectionexercise?reference=141&verbose=true", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var details models.CollectionExerciseDetails
+	err = json.NewDecoder(resp.Body).Decode(&details)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /collectionexercise', ", err.Error())
+	}
+	assert.Len(t, details.Data, 2)
+	assert.Equal(t, "ASHE", details.Data[0].Survey.ShortName)
+	assert.Len(t, details.Data[0].CollectionInstruments, 2)
+	assert.Equal(t, map[string]interface{}{"formType": "0002"}, details.Data[0].CollectionInstruments[1].Classifiers)
+	assert.Equal(t, "0bba3b39-2a41-4b5b-8e1a-ffd9c14c2ef1", details.Data[1].CollectionExercise.ExerciseUUID)
+	assert.Empty(t, details.Data[1].CollectionInstruments)
+}
+
+func TestGetExerciseByUUIDEndpoint(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	returnRows := mock.NewRows(exerciseColumnNames)
+	returnRows.AddRow("6f1bf642-2f9c-408f-8ffe-93b40667d99a", "141", "LIVE", "202009", nil, nil, nil, nil, nil, nil)
+
+	mock.ExpectQuery("SELECT (.+) WHERE 1=1 AND ce.exercise_uuid = \\$1").WithArgs("6f1bf642-2f9c-408f-8ffe-93b40667d99a").WillReturnRows(returnRows)
+
+	req := httptest.NewRequest("GET", "/collectionexercise/6f1bf642-2f9c-408f-8ffe-93b40667d99a", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var exercise models.CollectionExercise
+	err = json.NewDecoder(resp.Body).Decode(&exercise)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /collectionexercise/{uuid}', ", err.Error())
+	}
+	assert.Equal(t, "202009", exercise.PeriodName)
+}
+
+func TestGetExerciseByUUIDEndpointReturns404WhenNotFound(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectQuery(findExerciseDetailsQuery).WillReturnRows(mock.NewRows(exerciseDetailColumnNames))
+
+	req := httptest.NewRequest("GET", "/collectionexercise/6f1bf642-2f9c-408f-8ffe-93b40667d99a?verbose=true", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	var restError models.RESTError
+	err = json.NewDecoder(resp.Body).Decode(&restError)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /collectionexercise/{uuid}', ", err.Error())
+	}
+	assert.Equal(t, models.CodeExerciseNotFound, restError.Code)
+}
+
+func TestGetExercisesEndpointReturns400WhenInvalidParametersProvided(t *testing.T) {
+	setup()
+
+	db, _, _ = sqlmock.New()
+
+	req := httptest.NewRequest("GET", "/collectionexercise?periodName=202009", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestGetExercisesEndpointReturns400WhenStateIsInvalid(t *testing.T) {
+	setup()
+
+	db, _, _ = sqlmock.New()
+
+	req := httptest.NewRequest("GET", "/collectionexercise?state=FINISHED", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+var surveyWithInstrumentsColumns = append(append([]string{}, searchSurveyQueryColumns...), "instrument_uuid", "type", "classifiers", "seft_filename")
+
+var findSurveysWithInstrumentsQuery = "SELECT (.+) FROM surveyv2.survey s LEFT JOIN surveyv2.collection_instrument ci ON ci.survey_ref = s.survey_ref WHERE 1=1 AND s.short_name = \\$1"
+
+func TestGetSurveyEndpointIncludesInstruments(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	returnRows := mock.NewRows(surveyWithInstrumentsColumns)
+	returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT",
+		"ddc37cb6-c88a-473b-949a-fa5fad9265a1", "SEFT", []byte(`{"formType":"0001"}`), "seft_instrument.xls")
+	returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT",
+		"3f1f9a4c-5b1e-4c8e-9f0d-2b8a3c5d7e9f", "EQ", []byte(`{"formType":"0002","eqID":"2"}`), nil)
+	returnRows.AddRow("0f3c1a2e-7d4b-4e8a-9c6f-5b2d8e1a4c7f", "142", "ASHE", "Another ASHE", "Statistics of Trade Act 1947", "EQ",
+		nil, nil, nil, nil)
+
+	mock.ExpectQuery(findSurveysWithInstrumentsQuery).WithArgs("ASHE").WillReturnRows(returnRows)
+
+	req := httptest.NewRequest("GET", "/survey?shortName=ASHE&include=instruments", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var surveys models.Surveys
+	err = json.NewDecoder(resp.Body).Decode(&surveys)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /survey', ", err.Error())
+	}
+	assert.Len(t, surveys.Data, 2)
+	assert.Equal(t, "141", surveys.Data[0].Survey.SurveyRef)
+	assert.Len(t, surveys.Data[0].CollectionInstruments, 2)
+	assert.Equal(t, map[string]interface{}{"formType": "0002", "eqID": "2"}, surveys.Data[0].CollectionInstruments[1].Classifiers)
+	assert.Empty(t, surveys.Data[1].CollectionInstruments)
+}
+
+func TestGetSurveyByRefEndpointIncludesInstruments(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	returnRows := mock.NewRows(surveyWithInstrumentsColumns)
+	returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT",
+		"ddc37cb6-c88a-473b-949a-fa5fad9265a1", "SEFT", nil, "seft_instrument.xls")
+
+	mock.ExpectQuery("SELECT (.+) LEFT JOIN surveyv2.collection_instrument ci (.+) AND s.survey_ref = \\$1").WithArgs("141").WillReturnRows(returnRows)
+
+	req := httptest.NewRequest("GET", "/survey/141?include=instruments", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var survey models.SurveyWithInstruments
+	err = json.NewDecoder(resp.Body).Decode(&survey)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /survey/141', ", err.Error())
+	}
+	assert.Equal(t, "141", survey.Survey.SurveyRef)
+	assert.Equal(t, "seft_instrument.xls", survey.CollectionInstruments[0].SEFTFilename)
+}
+
+func TestGetSurveyEndpointReturns400WhenOnlyIncludeProvided(t *testing.T) {
+	setup()
+
+	db, _, _ = sqlmock.New()
+
+	req := httptest.NewRequest("GET", "/survey?include=instruments", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestGetSurveyEndpointReturns400WhenIncludeIsUnknown(t *testing.T) {
+	setup()
+
+	db, _, _ = sqlmock.New()
+
+	req := httptest.NewRequest("GET", "/survey?shortName=ASHE&include=exercises", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+var postExerciseExec = "INSERT INTO surveyv2.collection_exercise (.+)"
+
+func TestPostExerciseEndpoint(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	surveyRows := mock.NewRows(searchSurveyQueryColumns)
+	surveyRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT")
+
+	goLive := time.Date(2020, 9, 1, 9, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(findSurveyQuery).WithArgs("141").WillReturnRows(surveyRows)
+	mock.ExpectPrepare(postExerciseExec).ExpectExec().
+		WithArgs(sqlmock.AnyArg(), "141", "CREATED", "202009", nil, goLive, nil, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	var jsonStr = []byte(`{"surveyReference":"141","periodName":"202009","goLive":"2020-09-01T09:00:00Z"}`)
+	req := httptest.NewRequest("POST", "/collectionexercise", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var exercise models.CollectionExercise
+	err = json.NewDecoder(resp.Body).Decode(&exercise)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /collectionexercise', ", err.Error())
+	}
+	assert.Equal(t, "CREATED", exercise.State)
+	assert.NotEmpty(t, exercise.ExerciseUUID)
+}
+
+func TestPostExerciseEndpointReturns400WhenDatesAreOutOfOrder(t *testing.T) {
+	setup()
+
+	db, _, _ = sqlmock.New()
+
+	var jsonStr = []byte(`{"surveyReference":"141","periodName":"202009","mps":"2020-09-10T00:00:00Z","goLive":"2020-09-01T00:00:00Z"}`)
+	req := httptest.NewRequest("POST", "/collectionexercise", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	var restError models.RESTError
+	err := json.NewDecoder(resp.Body).Decode(&restError)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /collectionexercise', ", err.Error())
+	}
+	assert.Equal(t, models.CodeInvalidExerciseDates, restError.Code)
+	assert.Equal(t, "Invalid collection exercise dates: mps (2020-09-10T00:00:00Z) must be before go_live (2020-09-01T00:00:00Z)", restError.Message)
+}
+
+func TestPostExerciseEndpointReturns400WhenPeriodNameIsMissing(t *testing.T) {
+	setup()
+
+	db, _, _ = sqlmock.New()
+
+	var jsonStr = []byte(`{"surveyReference":"141"}`)
+	req := httptest.NewRequest("POST", "/collectionexercise", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestPostExerciseEndpointReturns404WhenSurveyNotFound(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(findSurveyQuery).WithArgs("141").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	var jsonStr = []byte(`{"surveyReference":"141","periodName":"202009"}`)
+	req := httptest.NewRequest("POST", "/collectionexercise", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestGetExerciseTimelineEndpoint(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	exerciseRows := mock.NewRows(exerciseColumnNames)
+	exerciseRows.AddRow("6f1bf642-2f9c-408f-8ffe-93b40667d99a", "141", "LIVE", "202009",
+		time.Date(2020, 8, 20, 0, 0, 0, 0, time.UTC), time.Date(2020, 9, 1, 9, 0, 0, 0, time.UTC), nil, nil, nil, time.Date(2020, 9, 30, 0, 0, 0, 0, time.UTC))
+	emailRows := mock.NewRows([]string{"type", "time_scheduled"})
+	emailRows.AddRow("Reminder 1", time.Date(2020, 9, 15, 9, 0, 0, 0, time.UTC))
+
+	mock.ExpectQuery("SELECT (.+) FROM surveyv2.collection_exercise ce WHERE 1=1 AND ce.exercise_uuid = \\$1").WillReturnRows(exerciseRows)
+	mock.ExpectQuery("SELECT (.+) FROM surveyv2.email e JOIN surveyv2.collection_exercise ce (.+)").WithArgs("6f1bf642-2f9c-408f-8ffe-93b40667d99a").WillReturnRows(emailRows)
+
+	req := httptest.NewRequest("GET", "/collectionexercise/6f1bf642-2f9c-408f-8ffe-93b40667d99a/timeline", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var timeline models.Timeline
+	err = json.NewDecoder(resp.Body).Decode(&timeline)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /collectionexercise/{uuid}/timeline', ", err.Error())
+	}
+	assert.Len(t, timeline.Events, 4)
+	assert.Equal(t, models.TimelineEvent{Type: models.EventTypeEmail, Name: "Reminder 1", Time: time.Date(2020, 9, 15, 9, 0, 0, 0, time.UTC)}, timeline.Events[2])
+	assert.Equal(t, "return", timeline.Events[3].Name)
+}
+
+func TestGetExerciseTimelineEndpointReturns404WhenNotFound(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM surveyv2.collection_exercise ce (.+)").WillReturnRows(mock.NewRows(exerciseColumnNames))
+
+	req := httptest.NewRequest("GET", "/collectionexercise/6f1bf642-2f9c-408f-8ffe-93b40667d99a/timeline", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestPostExerciseEndpointReturns409WhenPeriodExists(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	surveyRows := mock.NewRows(searchSurveyQueryColumns)
+	surveyRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(findSurveyQuery).WithArgs("141").WillReturnRows(surveyRows)
+	mock.ExpectPrepare(postExerciseExec).ExpectExec().
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "collection_exercise_survey_period_key"})
+	mock.ExpectRollback()
+	mock.ExpectQuery("SELECT exercise_uuid FROM surveyv2.collection_exercise WHERE survey_ref = \\$1 AND period_name = \\$2").
+		WithArgs("141", "202009").
+		WillReturnRows(mock.NewRows([]string{"exercise_uuid"}).AddRow("6f1bf642-2f9c-408f-8ffe-93b40667d99a"))
+
+	var jsonStr = []byte(`{"surveyReference":"141","periodName":"202009"}`)
+	req := httptest.NewRequest("POST", "/collectionexercise", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var exists models.ExerciseExistsError
+	err = json.NewDecoder(resp.Body).Decode(&exists)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /collectionexercise', ", err.Error())
+	}
+	assert.Equal(t, models.CodeExerciseExists, exists.Code)
+	assert.Equal(t, "6f1bf642-2f9c-408f-8ffe-93b40667d99a", exists.ExerciseUUID)
+}
+
+func TestPostSurveyEndpointReturns409WhenSurveyRefExists(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare(postSurveyExec).ExpectExec().
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "survey_survey_ref_key", Detail: "Key (survey_ref)=(141) already exists."})
+	mock.ExpectRollback()
+
+	var jsonStr = []byte(`{"reference":"141","shortName":"ASHE","longName":"Annual Survey of Hours and Earnings","legalBasis":"Statistics of Trade Act 1947","surveyMode":"SEFT"}`)
+	req := httptest.NewRequest("POST", "/survey", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	var restError models.RESTError
+	err = json.NewDecoder(resp.Body).Decode(&restError)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /survey', ", err.Error())
+	}
+	assert.Equal(t, models.CodeConflict, restError.Code)
+	assert.Equal(t, "The change conflicts with existing data: Key (survey_ref)=(141) already exists.", restError.Message)
+}
+
+var cloneExerciseSourceQuery = "SELECT exercise_id, survey_ref FROM surveyv2.collection_exercise WHERE exercise_uuid = \\$1"
+var cloneExerciseQuery = "INSERT INTO surveyv2.collection_exercise AS ce \\((.+)\\) SELECT (.+) FROM surveyv2.collection_exercise WHERE exercise_id = \\$1 RETURNING (.+)"
+
+func TestPostExerciseCloneEndpoint(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	cloneRows := mock.NewRows(append([]string{"exercise_id"}, exerciseColumnNames...))
+	cloneRows.AddRow(2, "0c6a2ba5-32e4-4b2a-a6ef-2d26b4c30e8c", "141", "CREATED", "202010",
+		time.Date(2020, 9, 20, 0, 0, 0, 0, time.UTC), time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC), nil, nil, nil, time.Date(2020, 10, 30, 0, 0, 0, 0, time.UTC))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(cloneExerciseSourceQuery).WithArgs("6f1bf642-2f9c-408f-8ffe-93b40667d99a").
+		WillReturnRows(mock.NewRows([]string{"exercise_id", "survey_ref"}).AddRow(1, "141"))
+	mock.ExpectQuery(cloneExerciseQuery).WithArgs(1, sqlmock.AnyArg(), 1, 0, "202010").WillReturnRows(cloneRows)
+	mock.ExpectExec("INSERT INTO surveyv2.associated_instruments (.+) SELECT \\$2, instrument_id (.+) WHERE exercise_id = \\$1").
+		WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO surveyv2.email (.+) SELECT \\$2, type, time_scheduled \\+ make_interval(.+) WHERE exercise_id = \\$1").
+		WithArgs(1, 2, 1, 0).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	var jsonStr = []byte(`{"periodName":"202010","shiftMonths":1}`)
+	req := httptest.NewRequest("POST", "/collectionexercise/6f1bf642-2f9c-408f-8ffe-93b40667d99a/clone", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var exercise models.CollectionExercise
+	err = json.NewDecoder(resp.Body).Decode(&exercise)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /collectionexercise/{uuid}/clone', ", err.Error())
+	}
+	assert.Equal(t, "0c6a2ba5-32e4-4b2a-a6ef-2d26b4c30e8c", exercise.ExerciseUUID)
+	assert.Equal(t, "CREATED", exercise.State)
+	assert.Equal(t, "202010", exercise.PeriodName)
+}
+
+func TestPostExerciseCloneEndpointReturns404WhenNotFound(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(cloneExerciseSourceQuery).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	var jsonStr = []byte(`{"periodName":"202010","shiftMonths":1}`)
+	req := httptest.NewRequest("POST", "/collectionexercise/6f1bf642-2f9c-408f-8ffe-93b40667d99a/clone", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostExerciseCloneEndpointReturns409WhenPeriodExists(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(cloneExerciseSourceQuery).WillReturnRows(mock.NewRows([]string{"exercise_id", "survey_ref"}).AddRow(1, "141"))
+	mock.ExpectQuery(cloneExerciseQuery).WillReturnError(&pq.Error{Code: "23505", Constraint: "collection_exercise_survey_period_key"})
+	mock.ExpectRollback()
+	mock.ExpectQuery("SELECT exercise_uuid FROM surveyv2.collection_exercise WHERE survey_ref = \\$1 AND period_name = \\$2").
+		WithArgs("141", "202010").
+		WillReturnRows(mock.NewRows([]string{"exercise_uuid"}).AddRow("0c6a2ba5-32e4-4b2a-a6ef-2d26b4c30e8c"))
+
+	var jsonStr = []byte(`{"periodName":"202010","shiftMonths":1}`)
+	req := httptest.NewRequest("POST", "/collectionexercise/6f1bf642-2f9c-408f-8ffe-93b40667d99a/clone", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var exists models.ExerciseExistsError
+	err = json.NewDecoder(resp.Body).Decode(&exists)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /collectionexercise/{uuid}/clone', ", err.Error())
+	}
+	assert.Equal(t, "0c6a2ba5-32e4-4b2a-a6ef-2d26b4c30e8c", exists.ExerciseUUID)
+}
+
+func TestPostExerciseCloneEndpointReturns400WhenShiftedDatesBreakRules(t *testing.T) {
+	setup()
+	config.ExerciseDateRules = map[string][]string{"141": {"go_live < return"}}
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	// The source exercise's dates were entered before the survey's rules were tightened
+	cloneRows := mock.NewRows(append([]string{"exercise_id"}, exerciseColumnNames...))
+	cloneRows.AddRow(2, "0c6a2ba5-32e4-4b2a-a6ef-2d26b4c30e8c", "141", "CREATED", "202010",
+		nil, time.Date(2020, 10, 30, 0, 0, 0, 0, time.UTC), nil, nil, nil, time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(cloneExerciseSourceQuery).WillReturnRows(mock.NewRows([]string{"exercise_id", "survey_ref"}).AddRow(1, "141"))
+	mock.ExpectQuery(cloneExerciseQuery).WillReturnRows(cloneRows)
+	mock.ExpectRollback()
+
+	var jsonStr = []byte(`{"periodName":"202010","shiftMonths":1}`)
+	req := httptest.NewRequest("POST", "/collectionexercise/6f1bf642-2f9c-408f-8ffe-93b40667d99a/clone", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var restError models.RESTError
+	err = json.NewDecoder(resp.Body).Decode(&restError)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /collectionexercise/{uuid}/clone', ", err.Error())
+	}
+	assert.Equal(t, models.CodeInvalidExerciseDates, restError.Code)
+}
+
+func TestPostExerciseCloneEndpointReturns400WhenPeriodNameIsMissing(t *testing.T) {
+	setup()
+
+	db, _, _ = sqlmock.New()
+
+	var jsonStr = []byte(`{"shiftMonths":1}`)
+	req := httptest.NewRequest("POST", "/collectionexercise/6f1bf642-2f9c-408f-8ffe-93b40667d99a/clone", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+var generateExercisesExec = "INSERT INTO surveyv2.collection_exercise (.+) ON CONFLICT ON CONSTRAINT collection_exercise_survey_period_key DO NOTHING"
+
+func TestGenerateExercisesEndpointSkipsExistingPeriods(t *testing.T) {
+	setup()
+	config.ExerciseSchedules = map[string]ExerciseSchedule{"141": {Frequency: "monthly", PeriodName: "YYYYMM", MPS: "-P10D", GoLive: "PT9H", Return: "P1M14D"}}
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	surveyRows := mock.NewRows(searchSurveyQueryColumns)
+	surveyRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(findSurveyQuery).WithArgs("141").WillReturnRows(surveyRows)
+	insert := mock.ExpectPrepare(generateExercisesExec)
+	insert.ExpectExec().WithArgs(sqlmock.AnyArg(), "141", "CREATED", "202101", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	insert.ExpectExec().WithArgs(sqlmock.AnyArg(), "141", "CREATED", "202102", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest("POST", "/survey/141/collectionexercise/generate?from=2021-01-01&to=2021-02-28", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var generated models.GeneratedExercises
+	err = json.NewDecoder(resp.Body).Decode(&generated)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /survey/141/collectionexercise/generate', ", err.Error())
+	}
+	assert.Equal(t, []string{"202101"}, generated.Skipped)
+	assert.Len(t, generated.Created, 1)
+	assert.Equal(t, "202102", generated.Created[0].PeriodName)
+	assert.NotEmpty(t, generated.Created[0].ExerciseUUID)
+	assert.Equal(t, time.Date(2021, 2, 1, 9, 0, 0, 0, time.UTC), *generated.Created[0].GoLive)
+}
+
+func TestGenerateExercisesEndpointReturns422WithoutSchedule(t *testing.T) {
+	setup()
+
+	db, _, _ = sqlmock.New()
+
+	req := httptest.NewRequest("POST", "/survey/141/collectionexercise/generate?from=2021-01-01&to=2021-12-31", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+
+	var restError models.RESTError
+	err := json.NewDecoder(resp.Body).Decode(&restError)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /survey/141/collectionexercise/generate', ", err.Error())
+	}
+	assert.Equal(t, models.CodeNoExerciseSchedule, restError.Code)
+}
+
+func TestGenerateExercisesEndpointReturns422WhenScheduleBreaksDateRules(t *testing.T) {
+	setup()
+	config.ExerciseSchedules = map[string]ExerciseSchedule{"141": {Frequency: "monthly", PeriodName: "YYYYMM", MPS: "P2D", GoLive: "P1D"}}
+
+	db, _, _ = sqlmock.New()
+
+	req := httptest.NewRequest("POST", "/survey/141/collectionexercise/generate?from=2021-01-01&to=2021-12-31", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+
+	var restError models.RESTError
+	err := json.NewDecoder(resp.Body).Decode(&restError)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /survey/141/collectionexercise/generate', ", err.Error())
+	}
+	assert.Equal(t, models.CodeInvalidExerciseDates, restError.Code)
+	assert.Equal(t, "The exercise schedule gives invalid dates for period 202101: mps (2021-01-03T00:00:00Z) must be before go_live (2021-01-02T00:00:00Z)", restError.Message)
+}
+
+func TestGenerateExercisesEndpointReturns400WhenRangeIsTooLong(t *testing.T) {
+	setup()
+	config.ExerciseSchedules = map[string]ExerciseSchedule{"141": {Frequency: "monthly", PeriodName: "YYYYMM"}}
+
+	db, _, _ = sqlmock.New()
+
+	req := httptest.NewRequest("POST", "/survey/141/collectionexercise/generate?from=2000-01-01&to=2020-12-31", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestGenerateExercisesEndpointReturns400WhenDateIsMissing(t *testing.T) {
+	setup()
+	config.ExerciseSchedules = map[string]ExerciseSchedule{"141": {Frequency: "monthly", PeriodName: "YYYYMM"}}
+
+	db, _, _ = sqlmock.New()
+
+	req := httptest.NewRequest("POST", "/survey/141/collectionexercise/generate?from=2021-01-01", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+var instrumentColumnNames = []string{"instrument_uuid", "type", "classifiers", "seft_filename"}
+
+func TestGetInstrumentsEndpointFiltersOnClassifiers(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	returnRows := mock.NewRows(instrumentColumnNames)
+	returnRows.AddRow("ddc37cb6-c88a-473b-949a-fa5fad9265a1", "EQ", []byte(`{"formType":"0001","eqID":"2"}`), nil)
+
+	mock.ExpectQuery("SELECT (.+) FROM surveyv2.collection_instrument ci WHERE 1=1 AND ci.survey_ref = \\$1 AND ci.type = \\$2 AND ci.classifiers @> \\$3::jsonb ORDER BY ci.instrument_id").
+		WithArgs("141", "EQ", `{"formType":"0001"}`).WillReturnRows(returnRows)
+
+	req := httptest.NewRequest("GET", "/collectioninstrument?survey=141&classifier.formType=0001&type=EQ", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var instruments models.CollectionInstruments
+	err = json.NewDecoder(resp.Body).Decode(&instruments)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /collectioninstrument', ", err.Error())
+	}
+	assert.Len(t, instruments.Data, 1)
+	assert.Equal(t, map[string]interface{}{"formType": "0001", "eqID": "2"}, instruments.Data[0].Classifiers)
+}
+
+func TestGetInstrumentsEndpointReturnsClassifiersThatArentStrings(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	returnRows := mock.NewRows(instrumentColumnNames)
+	returnRows.AddRow("ddc37cb6-c88a-473b-949a-fa5fad9265a1", "EQ", []byte(`{"formType":"0001","version":2,"welsh":true}`), nil)
+
+	mock.ExpectQuery("SELECT (.+) FROM surveyv2.collection_instrument ci WHERE 1=1 AND ci.survey_ref = \\$1").
+		WithArgs("141").WillReturnRows(returnRows)
+
+	req := httptest.NewRequest("GET", "/collectioninstrument?survey=141", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var instruments models.CollectionInstruments
+	err = json.NewDecoder(resp.Body).Decode(&instruments)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /collectioninstrument', ", err.Error())
+	}
+	assert.Equal(t, map[string]interface{}{"formType": "0001", "version": float64(2), "welsh": true}, instruments.Data[0].Classifiers)
+}
+
+func TestGetInstrumentsEndpointReturns404WhenNoneMatch(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM surveyv2.collection_instrument ci (.+)").WithArgs(`{"formType":"9999"}`).WillReturnRows(mock.NewRows(instrumentColumnNames))
+
+	req := httptest.NewRequest("GET", "/collectioninstrument?classifier.formType=9999", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestGetInstrumentsEndpointReturns400WhenParameterIsUnknown(t *testing.T) {
+	setup()
+
+	db, _, _ = sqlmock.New()
+
+	for _, query := range []string{"reference=141", "classifier.=0001"} {
+		resp = httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/collectioninstrument?"+query, nil)
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code, query)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/lib/pq"
+)
+
+// writeRESTError sends an error response carrying the request's correlation ID and logs it with the request logger
+func writeRESTError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	writeErrorBody(w, status, newRESTError(r, status, code, message))
+}
+
+// writeExerciseExists reports that a collection exercise couldn't be created because its survey already has one for
+// that period, giving the existing exercise's UUID
+func writeExerciseExists(w http.ResponseWriter, r *http.Request, exerciseUUID string) {
+	restError := newRESTError(r, http.StatusConflict, models.CodeExerciseExists, "A collection exercise already exists for that survey and period")
+	writeErrorBody(w, http.StatusConflict, models.ExerciseExistsError{RESTError: restError, ExerciseUUID: exerciseUUID})
+}
+
+// internalErrorMessage is all a client is told of an unexpected failure, as the error itself can give away details of
+// the database, such as constraint names or SQL
+const internalErrorMessage = "Internal server error"
+
+// writeInternalError reports an unexpected failure with internalErrorMessage, logging the error itself
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	writeErrorBody(w, http.StatusInternalServerError, newRESTError(r, http.StatusInternalServerError, models.CodeInternalError, internalErrorMessage, "error", err.Error()))
+}
+
+// newRESTError logs an error response, and any extra fields, with the request logger and returns its body
+func newRESTError(r *http.Request, status int, code string, message string, fields ...interface{}) models.RESTError {
+	log := logger.ForContext(r.Context())
+	fields = append([]interface{}{"code", code, "status", status}, fields...)
+	if status >= http.StatusInternalServerError {
+		log.Errorw(message, fields...)
+	} else {
+		log.Infow(message, fields...)
+	}
+
+	return models.RESTError{
+		Code:      code,
+		Message:   message,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		RequestID: requestIDFromContext(r.Context()),
+	}
+}
+
+func writeErrorBody(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeDBError reports a failed database call. Requests that ran out of time or were abandoned are reported as such,
+// as the driver doesn't always return the context's error. Postgres errors caused by the request, or that are worth
+// retrying, are reported by their error code rather than as a 500.
+func writeDBError(w http.ResponseWriter, r *http.Request, err error) {
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(r.Context().Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded):
+		writeRESTError(w, r, http.StatusGatewayTimeout, models.CodeRequestTimeout, "The request took too long to complete")
+	case errors.Is(r.Context().Err(), context.Canceled) || errors.Is(err, context.Canceled):
+		writeRESTError(w, r, http.StatusServiceUnavailable, models.CodeRequestCancelled, "The request was cancelled before it completed")
+	case errors.As(err, &pqErr):
+		status, code, message := pqErrorResponse(pqErr)
+		writeErrorBody(w, status, newRESTError(r, status, code, message, "error", err.Error()))
+	default:
+		writeInternalError(w, r, err)
+	}
+}
+
+// pqErrorResponse chooses the status, code and message for a Postgres error, by its SQLSTATE code
+func pqErrorResponse(err *pq.Error) (int, string, string) {
+	detail := err.Message
+	if err.Detail != "" {
+		detail = err.Detail
+	}
+
+	switch err.Code.Name() {
+	case "unique_violation", "foreign_key_violation":
+		return http.StatusConflict, models.CodeConflict, "The change conflicts with existing data: " + detail
+	case "not_null_violation", "check_violation":
+		return http.StatusBadRequest, models.CodeInvalidRequest, "The request breaks a database constraint: " + detail
+	case "query_canceled":
+		return http.StatusGatewayTimeout, models.CodeRequestTimeout, "The database cancelled the query: " + err.Message
+	case "serialization_failure", "deadlock_detected", "too_many_connections", "admin_shutdown", "crash_shutdown", "cannot_connect_now":
+		return http.StatusServiceUnavailable, models.CodeDatabaseUnavailable, "The database couldn't complete the request, try again: " + err.Message
+	}
+
+	switch err.Code.Class() {
+	case "22": // data exception, e.g. a malformed UUID or a value too long for its column
+		return http.StatusBadRequest, models.CodeInvalidRequest, "The database rejected a value: " + err.Message
+	case "08": // connection exception
+		return http.StatusServiceUnavailable, models.CodeDatabaseUnavailable, "The database couldn't complete the request, try again: " + err.Message
+	}
+	return http.StatusInternalServerError, models.CodeInternalError, internalErrorMessage
+}
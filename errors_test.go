@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ONSdigital/ras-rm-survey/logger"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestPQErrorResponse(t *testing.T) {
+	tests := []struct {
+		code   pq.ErrorCode
+		status int
+		rest   string
+	}{
+		{"23505", http.StatusConflict, models.CodeConflict},             // unique_violation
+		{"23503", http.StatusConflict, models.CodeConflict},             // foreign_key_violation
+		{"23502", http.StatusBadRequest, models.CodeInvalidRequest},     // not_null_violation
+		{"22P02", http.StatusBadRequest, models.CodeInvalidRequest},     // invalid_text_representation
+		{"57014", http.StatusGatewayTimeout, models.CodeRequestTimeout}, // query_canceled
+		{"40001", http.StatusServiceUnavailable, models.CodeDatabaseUnavailable},
+		{"08006", http.StatusServiceUnavailable, models.CodeDatabaseUnavailable},
+		{"42P01", http.StatusInternalServerError, models.CodeInternalError}, // undefined_table
+	}
+	for _, test := range tests {
+		status, code, _ := pqErrorResponse(&pq.Error{Code: test.code, Message: "message"})
+		assert.Equal(t, test.status, status, string(test.code))
+		assert.Equal(t, test.rest, code, string(test.code))
+	}
+}
+
+func TestUnexpectedDBErrorsAreLoggedButNotSent(t *testing.T) {
+	tests := []error{
+		&pq.Error{Code: "42P01", Message: `relation "surveyv2.survey" does not exist`},
+		errors.New(`sql: Scan error on column index 1, name "survey_ref": converting NULL to string is unsupported`),
+	}
+	for _, dbErr := range tests {
+		core, logs := observer.New(zap.InfoLevel)
+		defer func(previous *zap.SugaredLogger) { logger.Logger = previous }(logger.Logger)
+		logger.Logger = zap.New(core).Sugar()
+
+		setup()
+		var mock sqlmock.Sqlmock
+		var err error
+		db, mock, err = sqlmock.New()
+		if err != nil {
+			t.Fatal("Error setting up an SQL mock" + err.Error())
+		}
+		mock.ExpectQuery(findSurveyQuery).WillReturnError(dbErr)
+
+		req := httptest.NewRequest("GET", "/survey/141", nil)
+		req.Header.Set("X-Request-ID", "abc-123")
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		var restError models.RESTError
+		json.NewDecoder(resp.Body).Decode(&restError)
+		assert.Equal(t, models.CodeInternalError, restError.Code)
+		assert.Equal(t, "Internal server error", restError.Message)
+
+		logged := logs.FilterMessage("Internal server error").AllUntimed()
+		if assert.Len(t, logged, 1) {
+			assert.Equal(t, "abc-123", logged[0].ContextMap()["request_id"])
+			assert.Contains(t, logged[0].ContextMap()["error"], "survey")
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// eventPublisher sends collection exercise events on to the services that act on them
+type eventPublisher interface {
+	// Publish sends one event. Events are published at least once, so receivers should ignore an eventID they've
+	// already seen.
+	Publish(ctx context.Context, event models.ExerciseEvent) error
+}
+
+// exerciseEvents is where recorded events are published, set up at startup by openEventPublisher. It's nil if
+// event_publisher is none.
+var exerciseEvents eventPublisher
+
+// openEventPublisher sets up exerciseEvents for event_publisher
+func openEventPublisher() error {
+	switch config.Events.Publisher {
+	case "none":
+		exerciseEvents = nil
+	case "log":
+		exerciseEvents = logPublisher{}
+	case "http":
+		exerciseEvents = &httpPublisher{url: config.Events.URL, client: &http.Client{Timeout: config.Events.Timeout}}
+	default:
+		return fmt.Errorf("event_publisher %q must be one of none, log or http", config.Events.Publisher)
+	}
+	return nil
+}
+
+// logPublisher writes events to the log, where a log sink can route them on
+type logPublisher struct{}
+
+func (logPublisher) Publish(ctx context.Context, event models.ExerciseEvent) error {
+	logger.ForContext(ctx).Infow("Collection exercise event", "event", event)
+	return nil
+}
+
+// httpPublisher POSTs each event as JSON to event_publish_url, which must respond with a 2xx status
+type httpPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func (p *httpPublisher) Publish(ctx context.Context, event models.ExerciseEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", strconv.FormatInt(event.EventID, 10))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded %s", p.url, resp.Status)
+	}
+	return nil
+}
+
+// checkEventPublisher is the readiness check of event_publisher. Only the http publisher has anything to reach.
+func checkEventPublisher(ctx context.Context) error {
+	if p, ok := exerciseEvents.(*httpPublisher); ok {
+		return p.check(ctx)
+	}
+	return nil
+}
+
+// check sends a HEAD request to event_publish_url. The receiver may well only accept POST, so any response short of
+// a 5xx shows it's there.
+func (p *httpPublisher) check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s responded %s", p.url, resp.Status)
+	}
+	return nil
+}
+
+// runEventRelay publishes recorded events every event_publish_interval until ctx is cancelled
+func runEventRelay(ctx context.Context) {
+	ticker := time.NewTicker(config.Events.Interval)
+	defer ticker.Stop()
+	for {
+		runEventRelayPass(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runEventRelayPass publishes unpublished events if no other replica is doing so, so that events are published one
+// at a time and in order
+func runEventRelayPass(ctx context.Context) {
+	var published int
+	led, err := withTryLock(ctx, eventRelayLockKey(), func() (err error) {
+		published, err = publishEvents(ctx, config.Events.BatchSize)
+		return err
+	})
+	if published > 0 {
+		exerciseEventsPublishedTotal.Add(float64(published))
+		logger.Logger.Debugf("Published %d collection exercise events", published)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Logger.Error("Couldn't publish collection exercise events ", err)
+		}
+		return
+	}
+	if !led {
+		logger.Logger.Debug("Another replica holds the event relay lock, skipping this pass")
+	}
+}
+
+// eventRelayLockKey is the Postgres advisory lock key held by the replica publishing events of the configured schema
+func eventRelayLockKey() int64 {
+	return int64(crc32.ChecksumIEEE([]byte("ras-rm-survey:events:" + config.DB.Schema)))
+}
+
+// publishEvents publishes up to limit unpublished events, oldest first, and marks those it published. It stops at the
+// first that can't be published, so that it's retried before any later event is sent.
+func publishEvents(ctx context.Context, limit int) (published int, err error) {
+	defer observeQuery(ctx, "publish_events")(&err)
+
+	events, err := unpublishedEvents(ctx, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []int64
+	var publishErr error
+	for _, event := range events {
+		if publishErr = publishEvent(ctx, event); publishErr != nil {
+			publishErr = fmt.Errorf("couldn't publish event %d: %w", event.EventID, publishErr)
+			break
+		}
+		ids = append(ids, event.EventID)
+	}
+
+	if len(ids) > 0 {
+		_, err = db.ExecContext(ctx, "UPDATE "+schemaTable("exercise_event")+" SET published_at = now() WHERE event_id = ANY($1)", pq.Array(ids))
+		if err != nil {
+			return 0, fmt.Errorf("couldn't mark events as published: %w", err)
+		}
+	}
+	return len(ids), publishErr
+}
+
+// publishEvent publishes an event in a producer span continuing the trace that recorded it
+func publishEvent(ctx context.Context, event recordedEvent) error {
+	ctx, span := tracer().Start(withTraceparent(ctx, event.traceparent), "publish "+event.EventType,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.Int64("event.id", event.EventID),
+			attribute.String("exercise.uuid", event.ExerciseUUID),
+		),
+	)
+	defer span.End()
+
+	err := exerciseEvents.Publish(ctx, event.ExerciseEvent)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// recordedEvent is an exercise_event row waiting to be published
+type recordedEvent struct {
+	models.ExerciseEvent
+	traceparent string
+}
+
+// unpublishedEvents returns up to limit events that haven't been published, oldest first
+func unpublishedEvents(ctx context.Context, limit int) ([]recordedEvent, error) {
+	rows, err := db.QueryContext(ctx, "SELECT event_id, exercise_uuid, event_type, COALESCE(from_state, ''), to_state, occurred_at, COALESCE(traceparent, '')"+
+		" FROM "+schemaTable("exercise_event")+" WHERE published_at IS NULL ORDER BY event_id LIMIT $1", limit)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find unpublished events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []recordedEvent
+	for rows.Next() {
+		var e recordedEvent
+		if err := rows.Scan(&e.EventID, &e.ExerciseUUID, &e.EventType, &e.FromState, &e.ToState, &e.OccurredAt, &e.traceparent); err != nil {
+			return nil, fmt.Errorf("error scanning database rows: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var unpublishedEventsQuery = "SELECT event_id, exercise_uuid, event_type, (.+) FROM surveyv2.exercise_event WHERE published_at IS NULL ORDER BY event_id LIMIT \\$1"
+var eventColumns = []string{"event_id", "exercise_uuid", "event_type", "from_state", "to_state", "occurred_at", "traceparent"}
+
+// recordingPublisher keeps the events it's given, and the traceparent each was published in, failing with err from the
+// event with ID failFrom onwards
+type recordingPublisher struct {
+	events       []models.ExerciseEvent
+	traceparents []string
+	failFrom     int64
+	err          error
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event models.ExerciseEvent) error {
+	if p.err != nil && event.EventID >= p.failFrom {
+		return p.err
+	}
+	p.events = append(p.events, event)
+	p.traceparents = append(p.traceparents, traceparent(ctx))
+	return nil
+}
+
+func setupEvents(t *testing.T) (sqlmock.Sqlmock, *recordingPublisher) {
+	mock := setupTransitions(t)
+	publisher := &recordingPublisher{}
+	exerciseEvents = publisher
+	t.Cleanup(func() { exerciseEvents = nil })
+	return mock, publisher
+}
+
+func TestPublishEventsMarksThemPublished(t *testing.T) {
+	mock, publisher := setupEvents(t)
+
+	occurred := time.Date(2020, 9, 1, 9, 30, 0, 0, time.UTC)
+	mock.ExpectQuery(unpublishedEventsQuery).WithArgs(100).WillReturnRows(mock.NewRows(eventColumns).
+		AddRow(1, "6f1bf642-2f9c-408f-8ffe-93b40667d99a", "STATE_CHANGED", "READY_FOR_LIVE", "LIVE", occurred, "").
+		AddRow(2, "0c6a2ba5-32e4-4b2a-a6ef-2d26b4c30e8c", "STATE_CHANGED", "LIVE", "ENDED", occurred, ""))
+	mock.ExpectExec("UPDATE surveyv2.exercise_event SET published_at = now\\(\\) WHERE event_id = ANY\\(\\$1\\)").
+		WithArgs("{1,2}").WillReturnResult(sqlmock.NewResult(0, 2))
+
+	published, err := publishEvents(context.Background(), 100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, published)
+	assert.Equal(t, models.ExerciseEvent{EventID: 1, ExerciseUUID: "6f1bf642-2f9c-408f-8ffe-93b40667d99a", EventType: "STATE_CHANGED",
+		FromState: "READY_FOR_LIVE", ToState: "LIVE", OccurredAt: occurred}, publisher.events[0])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPublishEventsStopsAtTheFirstFailure(t *testing.T) {
+	mock, publisher := setupEvents(t)
+	publisher.failFrom, publisher.err = 2, errors.New("connection refused")
+
+	occurred := time.Date(2020, 9, 1, 9, 30, 0, 0, time.UTC)
+	mock.ExpectQuery(unpublishedEventsQuery).WillReturnRows(mock.NewRows(eventColumns).
+		AddRow(1, "6f1bf642-2f9c-408f-8ffe-93b40667d99a", "STATE_CHANGED", "READY_FOR_LIVE", "LIVE", occurred, "").
+		AddRow(2, "0c6a2ba5-32e4-4b2a-a6ef-2d26b4c30e8c", "STATE_CHANGED", "LIVE", "ENDED", occurred, "").
+		AddRow(3, "4f2a5ac1-6c3e-4a04-9a3b-7b4b7d9f3d1e", "STATE_CHANGED", "LIVE", "ENDED", occurred, ""))
+	mock.ExpectExec("UPDATE surveyv2.exercise_event SET published_at").WithArgs("{1}").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	published, err := publishEvents(context.Background(), 100)
+
+	assert.EqualError(t, err, "couldn't publish event 2: connection refused")
+	assert.Equal(t, 1, published)
+	assert.Len(t, publisher.events, 1, "nothing after the failed event should be published")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPublishEventsContinuesTheTraceThatRecordedThem(t *testing.T) {
+	mock, publisher := setupEvents(t)
+	recorder := setupTracing()
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	occurred := time.Date(2020, 9, 1, 9, 30, 0, 0, time.UTC)
+	mock.ExpectQuery(unpublishedEventsQuery).WillReturnRows(mock.NewRows(eventColumns).
+		AddRow(1, "6f1bf642-2f9c-408f-8ffe-93b40667d99a", "STATE_CHANGED", "READY_FOR_LIVE", "LIVE", occurred, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	mock.ExpectExec("UPDATE surveyv2.exercise_event SET published_at").WithArgs("{1}").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := publishEvents(context.Background(), 100)
+	assert.NoError(t, err)
+
+	var publishSpan sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "publish STATE_CHANGED" {
+			publishSpan = span
+		}
+	}
+	if !assert.NotNil(t, publishSpan) {
+		return
+	}
+	assert.Equal(t, trace.SpanKindProducer, publishSpan.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", publishSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", publishSpan.Parent().SpanID().String())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+publishSpan.SpanContext().SpanID().String()+"-01", publisher.traceparents[0])
+}
+
+func TestRunEventRelayPassSkipsWhenAnotherReplicaHoldsTheLock(t *testing.T) {
+	mock, publisher := setupEvents(t)
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").WithArgs(eventRelayLockKey()).WillReturnRows(mock.NewRows([]string{"locked"}).AddRow(false))
+
+	runEventRelayPass(context.Background())
+
+	assert.Empty(t, publisher.events)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHTTPPublisherPostsTheEvent(t *testing.T) {
+	setupTracing()
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	var received models.ExerciseEvent
+	var key, parent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("Idempotency-Key")
+		parent = r.Header.Get("traceparent")
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	event := models.ExerciseEvent{EventID: 7, ExerciseUUID: "6f1bf642-2f9c-408f-8ffe-93b40667d99a", EventType: "STATE_CHANGED",
+		FromState: "READY_FOR_LIVE", ToState: "LIVE", OccurredAt: time.Date(2020, 9, 1, 9, 30, 0, 0, time.UTC)}
+	publisher := &httpPublisher{url: server.URL, client: server.Client()}
+	ctx := withTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	assert.NoError(t, publisher.Publish(ctx, event))
+	assert.Equal(t, event, received)
+	assert.Equal(t, "7", key)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", parent)
+}
+
+func TestHTTPPublisherFailsOnAnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	publisher := &httpPublisher{url: server.URL, client: server.Client()}
+
+	err := publisher.Publish(context.Background(), models.ExerciseEvent{EventID: 7})
+	assert.EqualError(t, err, server.URL+" responded 503 Service Unavailable")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+)
+
+// exerciseDates are the dates of a collection exercise, by their column names, in the order they usually fall
+var exerciseDates = []string{"mps", "go_live", "period_start", "period_end", "employment", "return"}
+
+// defaultDateRules apply to surveys without their own rules in exercise_date_rules
+var defaultDateRules = []string{
+	"mps < go_live",
+	"go_live < return",
+	"period_start <= period_end",
+}
+
+// dateRule requires one exercise date to be before another, or on or before it if orEqual
+type dateRule struct {
+	earlier string
+	later   string
+	orEqual bool
+}
+
+// parseDateRule reads a rule written as "<date> < <date>" or "<date> <= <date>", e.g. "mps < go_live"
+func parseDateRule(rule string) (dateRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) != 3 || (fields[1] != "<" && fields[1] != "<=") {
+		return dateRule{}, fmt.Errorf("date rule %q must be written as <date> < <date> or <date> <= <date>", rule)
+	}
+	for _, date := range []string{fields[0], fields[2]} {
+		if !isExerciseDate(date) {
+			return dateRule{}, fmt.Errorf("date rule %q uses %s, which isn't one of %s", rule, date, strings.Join(exerciseDates, ", "))
+		}
+	}
+	return dateRule{earlier: fields[0], later: fields[2], orEqual: fields[1] == "<="}, nil
+}
+
+func (r dateRule) String() string {
+	if r.orEqual {
+		return r.earlier + " <= " + r.later
+	}
+	return r.earlier + " < " + r.later
+}
+
+// check returns a problem if both dates are set and out of order. Dates that haven't been set yet aren't checked.
+func (r dateRule) check(dates map[string]*time.Time) string {
+	earlier, later := dates[r.earlier], dates[r.later]
+	if earlier == nil || later == nil {
+		return ""
+	}
+	if later.After(*earlier) || (r.orEqual && later.Equal(*earlier)) {
+		return ""
+	}
+	order := "before"
+	if r.orEqual {
+		order = "on or before"
+	}
+	return fmt.Sprintf("%s (%s) must be %s %s (%s)", r.earlier, earlier.Format(time.RFC3339), order, r.later, later.Format(time.RFC3339))
+}
+
+// dateRulesFor returns the rules for a survey: its own from exercise_date_rules if it has any, otherwise the defaults.
+// The rules are checked by Config.validate at startup, so they parse.
+func dateRulesFor(surveyRef string) []dateRule {
+	rules, ok := config.ExerciseDateRules[surveyRef]
+	if !ok {
+		rules = defaultDateRules
+	}
+
+	parsed := make([]dateRule, 0, len(rules))
+	for _, rule := range rules {
+		if r, err := parseDateRule(rule); err == nil {
+			parsed = append(parsed, r)
+		}
+	}
+	return parsed
+}
+
+// checkExerciseDates returns every way the exercise's dates break its survey's rules
+func checkExerciseDates(exercise models.CollectionExercise) []string {
+	dates := datesByName(exercise)
+	var problems []string
+	for _, rule := range dateRulesFor(exercise.SurveyReference) {
+		if problem := rule.check(dates); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// exerciseTimeline merges the exercise's dates and its scheduled emails into one list of events in time order. Events
+// at the same time keep the order of exerciseDates, with emails after them.
+func exerciseTimeline(exercise models.CollectionExercise, emails []models.TimelineEvent) models.Timeline {
+	events := []models.TimelineEvent{}
+	dates := datesByName(exercise)
+	for _, name := range exerciseDates {
+		if date := dates[name]; date != nil {
+			events = append(events, models.TimelineEvent{Type: models.EventTypeDate, Name: name, Time: *date})
+		}
+	}
+	events = append(events, emails...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+
+	return models.Timeline{
+		ExerciseUUID: exercise.ExerciseUUID,
+		Events:       events,
+		Problems:     checkExerciseDates(exercise),
+	}
+}
+
+func datesByName(exercise models.CollectionExercise) map[string]*time.Time {
+	return map[string]*time.Time{
+		"mps":          exercise.MPS,
+		"go_live":      exercise.GoLive,
+		"period_start": exercise.PeriodStart,
+		"period_end":   exercise.PeriodEnd,
+		"employment":   exercise.Employment,
+		"return":       exercise.Return,
+	}
+}
+
+func isExerciseDate(name string) bool {
+	for _, date := range exerciseDates {
+		if date == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func date(day int) *time.Time {
+	d := time.Date(2020, 9, day, 9, 0, 0, 0, time.UTC)
+	return &d
+}
+
+func TestParseDateRule(t *testing.T) {
+	rule, err := parseDateRule("mps  <=  go_live")
+	assert.NoError(t, err)
+	assert.Equal(t, dateRule{earlier: "mps", later: "go_live", orEqual: true}, rule)
+	assert.Equal(t, "mps <= go_live", rule.String())
+
+	_, err = parseDateRule("mps > go_live")
+	assert.Error(t, err)
+	_, err = parseDateRule("mps < goLive")
+	assert.EqualError(t, err, `date rule "mps < goLive" uses goLive, which isn't one of mps, go_live, period_start, period_end, employment, return`)
+}
+
+func TestCheckExerciseDatesUsesDefaultRules(t *testing.T) {
+	config = defaultConfig()
+
+	valid := models.CollectionExercise{SurveyReference: "141", MPS: date(1), GoLive: date(2), Return: date(20), PeriodStart: date(1), PeriodEnd: date(1)}
+	assert.Empty(t, checkExerciseDates(valid))
+
+	invalid := models.CollectionExercise{SurveyReference: "141", MPS: date(3), GoLive: date(2), Return: date(2)}
+	assert.Equal(t, []string{
+		"mps (2020-09-03T09:00:00Z) must be before go_live (2020-09-02T09:00:00Z)",
+		"go_live (2020-09-02T09:00:00Z) must be before return (2020-09-02T09:00:00Z)",
+	}, checkExerciseDates(invalid))
+}
+
+func TestCheckExerciseDatesIgnoresUnsetDates(t *testing.T) {
+	config = defaultConfig()
+
+	assert.Empty(t, checkExerciseDates(models.CollectionExercise{SurveyReference: "141", GoLive: date(2)}))
+}
+
+func TestCheckExerciseDatesUsesSurveyRules(t *testing.T) {
+	config = defaultConfig()
+	config.ExerciseDateRules = map[string][]string{"141": {"employment <= mps"}}
+
+	exercise := models.CollectionExercise{SurveyReference: "141", MPS: date(3), GoLive: date(2), Employment: date(4)}
+	assert.Equal(t, []string{"employment (2020-09-04T09:00:00Z) must be on or before mps (2020-09-03T09:00:00Z)"}, checkExerciseDates(exercise))
+
+	exercise.SurveyReference = "142"
+	assert.Len(t, checkExerciseDates(exercise), 1, "other surveys keep the default rules")
+}
+
+func TestExerciseTimeline(t *testing.T) {
+	config = defaultConfig()
+
+	exercise := models.CollectionExercise{ExerciseUUID: "6f1bf642-2f9c-408f-8ffe-93b40667d99a", SurveyReference: "141",
+		MPS: date(1), GoLive: date(3), PeriodStart: date(1), Return: date(20)}
+	emails := []models.TimelineEvent{
+		{Type: models.EventTypeEmail, Name: "Go Live", Time: *date(3)},
+		{Type: models.EventTypeEmail, Name: "Reminder 1", Time: *date(10)},
+	}
+
+	timeline := exerciseTimeline(exercise, emails)
+
+	var names []string
+	for _, event := range timeline.Events {
+		names = append(names, event.Name)
+	}
+	assert.Equal(t, []string{"mps", "period_start", "go_live", "Go Live", "Reminder 1", "return"}, names)
+	assert.Empty(t, timeline.Problems)
+}
@@ -1,16 +1,22 @@
 module github.com/ONSdigital/ras-rm-survey
 
-go 1.14
+go 1.16
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/getkin/kin-openapi v0.80.0
 	github.com/gofrs/uuid v4.0.0+incompatible
 	github.com/golang-migrate/migrate/v4 v4.13.0
 	github.com/gorilla/mux v1.8.0
-	github.com/julienschmidt/httprouter v1.2.0
 	github.com/lib/pq v1.9.0
+	github.com/prometheus/client_golang v1.9.0
 	github.com/spf13/viper v1.7.1
-	github.com/stretchr/testify v1.6.1
+	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	go.uber.org/zap v1.16.0
 	golang.org/x/text v0.3.4 // indirect
 )
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+)
+
+// readinessCheck is a dependency that must be usable before the service can take traffic
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// readinessChecks are run by /health/ready. Anything else the service comes to depend on should add itself here.
+var readinessChecks = []readinessCheck{
+	{name: "database", check: pingDB},
+	{name: "schema", check: checkSchemaVersion},
+	{name: "events", check: checkEventPublisher},
+}
+
+func pingDB(ctx context.Context) error {
+	if db == nil {
+		return errors.New("no database connection")
+	}
+	return db.PingContext(ctx)
+}
+
+// showLive tells Kubernetes the process is running and able to answer requests. It doesn't look at dependencies,
+// so an unavailable database makes the pod unready rather than getting it restarted.
+func showLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(models.Health{Status: models.StatusUp})
+}
+
+// showReady runs every readiness check concurrently, each with its own timeout, and returns 503 unless all of them
+// pass
+func showReady(w http.ResponseWriter, r *http.Request) {
+	health := models.Health{
+		Status:     models.StatusUp,
+		Components: make(map[string]models.ComponentHealth, len(readinessChecks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range readinessChecks {
+		wg.Add(1)
+		go func(c readinessCheck) {
+			defer wg.Done()
+			component := runReadinessCheck(r.Context(), c)
+
+			mu.Lock()
+			defer mu.Unlock()
+			health.Components[c.name] = component
+			if component.Status != models.StatusUp {
+				health.Status = models.StatusDown
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if health.Status != models.StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
+
+func runReadinessCheck(ctx context.Context, c readinessCheck) models.ComponentHealth {
+	ctx, cancel := context.WithTimeout(ctx, config.HTTP.ReadinessCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.check(ctx)
+	latency := time.Since(start).Truncate(time.Millisecond).String()
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = errors.New("timed out")
+		}
+		return models.ComponentHealth{Status: models.StatusDown, Latency: latency, Error: err.Error()}
+	}
+	return models.ComponentHealth{Status: models.StatusUp, Latency: latency}
+}
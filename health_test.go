@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupHealth(t *testing.T) sqlmock.Sqlmock {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+	// The checks run concurrently
+	mock.MatchExpectationsInOrder(false)
+	return mock
+}
+
+func decodeHealth(t *testing.T) models.Health {
+	var health models.Health
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatal("Error decoding JSON health response, ", err.Error())
+	}
+	return health
+}
+
+func TestLiveEndpointIgnoresDependencies(t *testing.T) {
+	setup()
+	db = nil
+
+	req := httptest.NewRequest("GET", "/health/live", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, models.StatusUp, decodeHealth(t).Status)
+}
+
+func TestReadyEndpoint(t *testing.T) {
+	mock := setupHealth(t)
+	mock.ExpectPing()
+	mock.ExpectQuery(schemaVersionQuery).WillReturnRows(mock.NewRows(schemaVersionColumns).AddRow(latestSchemaVersion(t), false))
+
+	req := httptest.NewRequest("GET", "/health/ready", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	health := decodeHealth(t)
+	assert.Equal(t, models.StatusUp, health.Status)
+	assert.Equal(t, models.StatusUp, health.Components["database"].Status)
+	assert.Equal(t, models.StatusUp, health.Components["schema"].Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadyEndpointReturns503WhenSchemaIsBehind(t *testing.T) {
+	mock := setupHealth(t)
+	mock.ExpectPing()
+	mock.ExpectQuery(schemaVersionQuery).WillReturnRows(mock.NewRows(schemaVersionColumns))
+
+	req := httptest.NewRequest("GET", "/health/ready", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	health := decodeHealth(t)
+	assert.Equal(t, models.StatusDown, health.Status)
+	assert.Equal(t, models.StatusUp, health.Components["database"].Status)
+	assert.Equal(t, fmt.Sprintf("schema version 0 is behind the expected version %d, run 'migrate up'", latestSchemaVersion(t)), health.Components["schema"].Error)
+}
+
+func TestReadyEndpointReturns503WhenDatabaseIsDown(t *testing.T) {
+	mock := setupHealth(t)
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectQuery(schemaVersionQuery).WillReturnError(errors.New("connection refused"))
+
+	req := httptest.NewRequest("GET", "/health/ready", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	health := decodeHealth(t)
+	assert.Equal(t, models.StatusDown, health.Components["database"].Status)
+	assert.Equal(t, "connection refused", health.Components["database"].Error)
+}
+
+func TestReadyEndpointTimesOutSlowChecks(t *testing.T) {
+	mock := setupHealth(t)
+	config.HTTP.ReadinessCheckTimeout = 20 * time.Millisecond
+
+	mock.ExpectPing().WillDelayFor(time.Second)
+	mock.ExpectQuery(schemaVersionQuery).WillReturnRows(mock.NewRows(schemaVersionColumns).AddRow(latestSchemaVersion(t), false))
+
+	start := time.Now()
+	req := httptest.NewRequest("GET", "/health/ready", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond), "slow checks should be abandoned at the timeout")
+	health := decodeHealth(t)
+	assert.Equal(t, "timed out", health.Components["database"].Error)
+	assert.Equal(t, models.StatusUp, health.Components["schema"].Status)
+}
+
+func TestReadyEndpointChecksTheEventReceiver(t *testing.T) {
+	mock := setupHealth(t)
+	mock.ExpectPing()
+	mock.ExpectQuery(schemaVersionQuery).WillReturnRows(mock.NewRows(schemaVersionColumns).AddRow(latestSchemaVersion(t), false))
+
+	var method string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer server.Close()
+	exerciseEvents = &httpPublisher{url: server.URL, client: server.Client()}
+	t.Cleanup(func() { exerciseEvents = nil })
+
+	req := httptest.NewRequest("GET", "/health/ready", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, models.StatusUp, decodeHealth(t).Components["events"].Status)
+	assert.Equal(t, http.MethodHead, method)
+}
+
+func TestReadyEndpointReturns503WhenEventReceiverFails(t *testing.T) {
+	mock := setupHealth(t)
+	mock.ExpectPing()
+	mock.ExpectQuery(schemaVersionQuery).WillReturnRows(mock.NewRows(schemaVersionColumns).AddRow(latestSchemaVersion(t), false))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	exerciseEvents = &httpPublisher{url: server.URL, client: server.Client()}
+	t.Cleanup(func() { exerciseEvents = nil })
+
+	req := httptest.NewRequest("GET", "/health/ready", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	health := decodeHealth(t)
+	assert.Equal(t, models.StatusUp, health.Components["database"].Status)
+	assert.Equal(t, server.URL+" responded 502 Bad Gateway", health.Components["events"].Error)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+)
+
+// maxInstrumentPartSize limits the collectionInstrument part of an upload, and everything but the file in the body
+const maxInstrumentPartSize = 64 << 10
+
+// seftFileType is a type of file a SEFT instrument can be, with the bytes every file of the type starts with
+type seftFileType struct {
+	contentType string
+	magic       []byte
+}
+
+// seftFileTypes are the types of file a SEFT instrument can be, by extension
+var seftFileTypes = map[string]seftFileType{
+	// Excel 97-2003 workbooks are OLE compound files
+	".xls": {contentType: "application/vnd.ms-excel", magic: []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}},
+	// Office Open XML workbooks are zip files
+	".xlsx": {contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", magic: []byte("PK\x03\x04")},
+}
+
+var (
+	errFileType     = errors.New("SEFT files must be .xls or .xlsx")
+	errFileTooLarge = errors.New("file too large")
+	errFileContents = errors.New("file contents don't match its type")
+)
+
+// isBodyTooLarge reports whether err is http.MaxBytesReader refusing to read past the upload limit. Its error isn't
+// exported, so it's recognised by its message.
+func isBodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "request body too large")
+}
+
+// seftUpload reads an uploaded SEFT file as it's stored, hashing it and failing once it's too large or if it doesn't
+// start like a file of its type
+type seftUpload struct {
+	r     io.Reader
+	magic []byte
+	limit int64
+	head  []byte
+	size  int64
+	hash  hash.Hash
+}
+
+func newSEFTUpload(r io.Reader, fileType seftFileType, limit int64) *seftUpload {
+	return &seftUpload{r: r, magic: fileType.magic, limit: limit, hash: sha256.New()}
+}
+
+func (u *seftUpload) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	if n > 0 {
+		if len(u.head) < len(u.magic) {
+			u.head = append(u.head, p[:minInt(n, len(u.magic)-len(u.head))]...)
+			if !bytes.HasPrefix(u.magic, u.head) {
+				return 0, errFileContents
+			}
+		}
+		u.size += int64(n)
+		if u.size > u.limit {
+			return 0, errFileTooLarge
+		}
+		u.hash.Write(p[:n])
+	}
+	if err == io.EOF && len(u.head) < len(u.magic) {
+		return n, errFileContents
+	}
+	return n, err
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Add a collection instrument to a survey. The body is multipart/form-data, with the instrument as JSON in the
+// collectionInstrument part and, for a SEFT instrument, its .xls or .xlsx file in the SEFTFile part. The file is
+// checked and stored as it's read, rather than being held in memory.
+func postInstrument(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, config.Instruments.MaxUploadSize+maxInstrumentPartSize)
+	parts, err := r.MultipartReader()
+	if err != nil {
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidRequest, "The body must be multipart/form-data")
+		return
+	}
+
+	newID, err := uuid.NewV4()
+	if err != nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeInternalError, "Error generating random uuid")
+		return
+	}
+	instrumentUUID := newID.String()
+
+	var instrument *models.CollectionInstrument
+	var file *instrumentFile
+	created := false
+	defer func() {
+		// Don't leave the file of an instrument that wasn't created in storage
+		if file != nil && !created {
+			if err := instrumentFiles.Delete(r.Context(), instrumentUUID); err != nil {
+				logger.ForContext(r.Context()).Warnw("Couldn't delete the file of an instrument that wasn't created", "instrument_uuid", instrumentUUID, "error", err.Error())
+			}
+		}
+	}()
+
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if isBodyTooLarge(err) {
+			writeFileError(w, r, errFileTooLarge)
+			return
+		}
+		if err != nil {
+			writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidRequest, "Couldn't read the multipart body: "+err.Error())
+			return
+		}
+
+		switch part.FormName() {
+		case "collectionInstrument":
+			instrument = &models.CollectionInstrument{}
+			if err := json.NewDecoder(io.LimitReader(part, maxInstrumentPartSize)).Decode(instrument); err != nil {
+				if isBodyTooLarge(err) {
+					writeFileError(w, r, errFileTooLarge)
+					return
+				}
+				writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidJSON, "Error unmarshalling JSON")
+				return
+			}
+		case "SEFTFile":
+			if file != nil {
+				writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidRequest, "Only one SEFTFile can be uploaded")
+				return
+			}
+			if file, err = storeSEFTFile(r, instrumentUUID, part); err != nil {
+				writeFileError(w, r, err)
+				return
+			}
+		default:
+			writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidRequest, "Unknown part "+part.FormName())
+			return
+		}
+	}
+
+	switch {
+	case instrument == nil:
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidRequest, "The collectionInstrument part is required")
+		return
+	case instrument.InstrumentType == "SEFT" && file == nil:
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidRequest, "A SEFT instrument needs a SEFTFile")
+		return
+	case instrument.InstrumentType != "SEFT" && file != nil:
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidRequest, "Only SEFT instruments have a SEFTFile")
+		return
+	}
+	instrument.InstrumentUUID = instrumentUUID
+	instrument.SEFTFilename = ""
+	if file != nil {
+		instrument.SEFTFilename = file.filename
+	}
+
+	err = createInstrument(r.Context(), mux.Vars(r)["surveyRef"], *instrument, file)
+	if err == errSurveyNotFound {
+		writeRESTError(w, r, http.StatusNotFound, models.CodeSurveyNotFound, "Survey not found")
+		return
+	}
+	if err != nil {
+		writeDBError(w, r, err)
+		return
+	}
+	created = true
+
+	logger.ForContext(r.Context()).Infow("Successfully created collection instrument", "instrument_uuid", instrumentUUID)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(instrument)
+}
+
+// storeSEFTFile checks the uploaded file's type and stores it under the instrument's UUID as it's read
+func storeSEFTFile(r *http.Request, instrumentUUID string, part *multipart.Part) (*instrumentFile, error) {
+	if instrumentFiles == nil {
+		return nil, errors.New("file storage could not be found")
+	}
+
+	// Browsers on Windows send the whole path
+	filename := path.Base(strings.ReplaceAll(part.FileName(), `\`, "/"))
+	fileType, ok := seftFileTypes[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errFileType, filename)
+	}
+
+	upload := newSEFTUpload(part, fileType, config.Instruments.MaxUploadSize)
+	if err := instrumentFiles.Put(r.Context(), instrumentUUID, upload); err != nil {
+		return nil, err
+	}
+	return &instrumentFile{filename: filename, size: upload.size, sha256: upload.hash.Sum(nil)}, nil
+}
+
+// writeFileError reports a SEFT file that couldn't be stored
+func writeFileError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errFileTooLarge) || isBodyTooLarge(err):
+		writeRESTError(w, r, http.StatusRequestEntityTooLarge, models.CodeFileTooLarge,
+			fmt.Sprintf("SEFT files can be at most %d bytes", config.Instruments.MaxUploadSize))
+	case errors.Is(err, errFileType):
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidFile, err.Error())
+	case errors.Is(err, errFileContents):
+		writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidFile, "The SEFTFile isn't the type of spreadsheet its name says it is")
+	default:
+		writeInternalError(w, r, fmt.Errorf("couldn't store the SEFTFile: %w", err))
+	}
+}
+
+// Download a SEFT collection instrument's file. Range requests are supported, and the file's SHA-256 is sent as a
+// Digest header and its ETag.
+func getInstrumentFile(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+	if instrumentFiles == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeInternalError, "File storage could not be found")
+		return
+	}
+
+	instrumentUUID := mux.Vars(r)["uuid"]
+	file, err := findInstrumentFile(r.Context(), instrumentUUID)
+	if err == errInstrumentNotFound {
+		writeRESTError(w, r, http.StatusNotFound, models.CodeInstrumentNotFound, "Collection instrument not found, or it has no file")
+		return
+	}
+	if err != nil {
+		writeDBError(w, r, err)
+		return
+	}
+
+	content, err := instrumentFiles.Open(r.Context(), instrumentUUID)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("couldn't open the instrument's file: %w", err))
+		return
+	}
+	defer content.Close()
+
+	contentType := "application/octet-stream"
+	if fileType, ok := seftFileTypes[strings.ToLower(filepath.Ext(file.filename))]; ok {
+		contentType = fileType.contentType
+	}
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.filename}))
+	header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(file.sha256))
+	header.Set("ETag", `"`+hex.EncodeToString(file.sha256)+`"`)
+	http.ServeContent(w, r, file.filename, time.Time{}, content)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// xlsxContents starts like an .xlsx file
+var xlsxContents = []byte("PK\x03\x04 the rest of the workbook")
+
+var createInstrumentExec = "INSERT INTO surveyv2.collection_instrument \\(survey_ref, instrument_uuid, type, classifiers, seft_filename, file_size, file_sha256\\)"
+var findInstrumentFileQuery = "SELECT seft_filename, file_size, file_sha256 FROM surveyv2.collection_instrument WHERE instrument_uuid = \\$1 AND file_sha256 IS NOT NULL"
+
+func setupInstrumentFiles(t *testing.T) (sqlmock.Sqlmock, string) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	dir := t.TempDir()
+	if instrumentFiles, err = newDirStore(dir); err != nil {
+		t.Fatal("Error setting up the file store, ", err.Error())
+	}
+	t.Cleanup(func() { instrumentFiles = nil })
+	return mock, dir
+}
+
+// uploadRequest builds a multipart upload of an instrument and, if filename isn't empty, its file
+func uploadRequest(t *testing.T, instrument string, filename string, contents []byte) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormField("collectionInstrument")
+	part.Write([]byte(instrument))
+	if filename != "" {
+		part, _ = form.CreateFormFile("SEFTFile", filename)
+		part.Write(contents)
+	}
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/survey/141/collectioninstrument", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func surveyRows(mock sqlmock.Sqlmock) *sqlmock.Rows {
+	return mock.NewRows(searchSurveyQueryColumns).
+		AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "141", "ASHE", "Annual Survey of Hours and Earnings", "Statistics of Trade Act 1947", "SEFT")
+}
+
+func TestPostInstrumentEndpointStoresSEFTFile(t *testing.T) {
+	mock, _ := setupInstrumentFiles(t)
+
+	sum := sha256.Sum256(xlsxContents)
+	mock.ExpectBegin()
+	mock.ExpectQuery(findSurveyQuery).WithArgs("141").WillReturnRows(surveyRows(mock))
+	mock.ExpectExec(createInstrumentExec).
+		WithArgs("141", sqlmock.AnyArg(), "SEFT", []byte(`{"formType":"0001"}`), "seft_instrument.xlsx", int64(len(xlsxContents)), sum[:]).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	router.ServeHTTP(resp, uploadRequest(t, `{"instrumentType":"SEFT","classifiers":{"formType":"0001"}}`, `C:\Users\admin\seft_instrument.xlsx`, xlsxContents))
+
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var instrument models.CollectionInstrument
+	err := json.NewDecoder(resp.Body).Decode(&instrument)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /survey/141/collectioninstrument', ", err.Error())
+	}
+	assert.Equal(t, "seft_instrument.xlsx", instrument.SEFTFilename)
+
+	stored, err := instrumentFiles.Open(context.Background(), instrument.InstrumentUUID)
+	if err != nil {
+		t.Fatal("Error opening the stored file, ", err.Error())
+	}
+	defer stored.Close()
+	contents, _ := ioutil.ReadAll(stored)
+	assert.Equal(t, xlsxContents, contents)
+}
+
+func TestPostInstrumentEndpointRejectsFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		contents []byte
+		status   int
+		code     string
+	}{
+		{"wrong extension", "seft_instrument.csv", []byte("a,b,c"), http.StatusBadRequest, models.CodeInvalidFile},
+		{"contents not matching extension", "seft_instrument.xls", xlsxContents, http.StatusBadRequest, models.CodeInvalidFile},
+		{"too short to be a spreadsheet", "seft_instrument.xlsx", []byte("PK"), http.StatusBadRequest, models.CodeInvalidFile},
+		{"too large", "seft_instrument.xlsx", append(xlsxContents, make([]byte, 1024)...), http.StatusRequestEntityTooLarge, models.CodeFileTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mock, dir := setupInstrumentFiles(t)
+			config.Instruments.MaxUploadSize = 1024
+
+			router.ServeHTTP(resp, uploadRequest(t, `{"instrumentType":"SEFT"}`, test.filename, test.contents))
+
+			assert.Equal(t, test.status, resp.Code)
+			var restError models.RESTError
+			json.NewDecoder(resp.Body).Decode(&restError)
+			assert.Equal(t, test.code, restError.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			files, _ := ioutil.ReadDir(dir)
+			assert.Empty(t, files, "nothing should be stored")
+		})
+	}
+}
+
+func TestPostInstrumentEndpointReturns413WhenTheBodyIsTooLarge(t *testing.T) {
+	mock, dir := setupInstrumentFiles(t)
+	config.Instruments.MaxUploadSize = 1024
+
+	// The file is small enough, but the body as a whole is larger than an upload can be
+	padded := `{"instrumentType":"SEFT"}` + strings.Repeat(" ", maxInstrumentPartSize+2048)
+	router.ServeHTTP(resp, uploadRequest(t, padded, "seft_instrument.xlsx", xlsxContents))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	var restError models.RESTError
+	json.NewDecoder(resp.Body).Decode(&restError)
+	assert.Equal(t, models.CodeFileTooLarge, restError.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	files, _ := ioutil.ReadDir(dir)
+	assert.Empty(t, files)
+}
+
+func TestPostInstrumentEndpointDeletesFileWhenSurveyNotFound(t *testing.T) {
+	mock, dir := setupInstrumentFiles(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(findSurveyQuery).WithArgs("141").WillReturnRows(mock.NewRows(searchSurveyQueryColumns))
+	mock.ExpectRollback()
+
+	router.ServeHTTP(resp, uploadRequest(t, `{"instrumentType":"SEFT"}`, "seft_instrument.xlsx", xlsxContents))
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Empty(t, files)
+}
+
+func TestPostInstrumentEndpointRequiresFileForSEFT(t *testing.T) {
+	setupInstrumentFiles(t)
+
+	router.ServeHTTP(resp, uploadRequest(t, `{"instrumentType":"SEFT"}`, "", nil))
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+// storeInstrumentFile stores xlsxContents for the instrument and expects its details to be looked up
+func storeInstrumentFile(t *testing.T, mock sqlmock.Sqlmock) [32]byte {
+	if err := instrumentFiles.Put(context.Background(), "ddc37cb6-c88a-473b-949a-fa5fad9265a1", bytes.NewReader(xlsxContents)); err != nil {
+		t.Fatal("Error storing the file, ", err.Error())
+	}
+	sum := sha256.Sum256(xlsxContents)
+	mock.ExpectQuery(findInstrumentFileQuery).WithArgs("ddc37cb6-c88a-473b-949a-fa5fad9265a1").
+		WillReturnRows(mock.NewRows([]string{"seft_filename", "file_size", "file_sha256"}).AddRow("seft_instrument.xlsx", len(xlsxContents), sum[:]))
+	return sum
+}
+
+func TestGetInstrumentFileEndpoint(t *testing.T) {
+	mock, _ := setupInstrumentFiles(t)
+	sum := storeInstrumentFile(t, mock)
+
+	req := httptest.NewRequest("GET", "/collectioninstrument/ddc37cb6-c88a-473b-949a-fa5fad9265a1/file", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, xlsxContents, resp.Body.Bytes())
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", resp.Header().Get("Content-Type"))
+	assert.Equal(t, "attachment; filename=seft_instrument.xlsx", resp.Header().Get("Content-Disposition"))
+	assert.Equal(t, "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]), resp.Header().Get("Digest"))
+	assert.Equal(t, "bytes", resp.Header().Get("Accept-Ranges"))
+}
+
+func TestGetInstrumentFileEndpointServesRanges(t *testing.T) {
+	mock, _ := setupInstrumentFiles(t)
+	storeInstrumentFile(t, mock)
+
+	req := httptest.NewRequest("GET", "/collectioninstrument/ddc37cb6-c88a-473b-949a-fa5fad9265a1/file", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusPartialContent, resp.Code)
+	assert.Equal(t, []byte("PK\x03\x04"), resp.Body.Bytes())
+	assert.Equal(t, fmt.Sprintf("bytes 0-3/%d", len(xlsxContents)), resp.Header().Get("Content-Range"))
+	assert.NotEmpty(t, resp.Header().Get("Digest"))
+}
+
+func TestGetInstrumentFileEndpointReturns416ForRangeOutsideFile(t *testing.T) {
+	mock, _ := setupInstrumentFiles(t)
+	storeInstrumentFile(t, mock)
+
+	req := httptest.NewRequest("GET", "/collectioninstrument/ddc37cb6-c88a-473b-949a-fa5fad9265a1/file", nil)
+	req.Header.Set("Range", "bytes=1000-")
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.Code)
+}
+
+func TestGetInstrumentFileEndpointReturns404WithoutFile(t *testing.T) {
+	mock, _ := setupInstrumentFiles(t)
+	mock.ExpectQuery(findInstrumentFileQuery).WillReturnRows(mock.NewRows([]string{"seft_filename", "file_size", "file_sha256"}))
+
+	req := httptest.NewRequest("GET", "/collectioninstrument/ddc37cb6-c88a-473b-949a-fa5fad9265a1/file", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
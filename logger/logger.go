@@ -1,7 +1,12 @@
 package logger
 
 import (
-	"github.com/spf13/viper"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -13,6 +18,24 @@ Adapted from the logging code in Census
 // Logger is a singleton logger
 var Logger *zap.SugaredLogger
 
+// level is shared by every logger ConfigureLogger builds, so LevelHandler can change it without a restart
+var level = zap.NewAtomicLevel()
+
+// format and gcpProject are the settings the current Logger was built with
+var (
+	format     = "json"
+	gcpProject = ""
+)
+
+// Config is the logging configuration, loaded by the main package
+type Config struct {
+	Level              string `mapstructure:"log_level"`
+	Format             string `mapstructure:"log_format"`
+	SamplingInitial    int    `mapstructure:"log_sampling_initial"`
+	SamplingThereafter int    `mapstructure:"log_sampling_thereafter"`
+	GCPProjectID       string `mapstructure:"gcp_project_id"`
+}
+
 func getZapLevel(textLevel string) (zap.AtomicLevel, error) {
 	level := zap.AtomicLevel{}
 	err := level.UnmarshalText([]byte(textLevel))
@@ -25,33 +48,166 @@ func init() {
 	Logger = initLogger.Sugar()
 }
 
+// Validate reports whether ConfigureLogger would accept c
+func (c Config) Validate() error {
+	if _, err := getZapLevel(c.Level); err != nil {
+		return fmt.Errorf("invalid log_level: %w", err)
+	}
+	_, err := encoderConfig(c.Format)
+	return err
+}
+
 // ConfigureLogger configures the logger for the app
-func ConfigureLogger() error {
-	logLevel, err := getZapLevel(viper.GetString("log_level"))
+func ConfigureLogger(c Config) error {
+	logLevel, err := getZapLevel(c.Level)
 	if err != nil {
 		return err
 	}
-	initLogger, err := zap.Config{
-		Encoding:         "json",
-		Level:            logLevel,
-		OutputPaths:      []string{"stdout"},
-		ErrorOutputPaths: []string{"stdout"},
-		EncoderConfig: zapcore.EncoderConfig{
-			MessageKey: "message",
-
-			LevelKey:    "severity",
-			EncodeLevel: zapcore.CapitalLevelEncoder,
-
-			TimeKey:    "timestamp",
-			EncodeTime: zapcore.RFC3339NanoTimeEncoder,
-
-			CallerKey:    "caller",
-			EncodeCaller: zapcore.ShortCallerEncoder,
-		}}.Build()
+	encoding, err := encoderConfig(c.Format)
 	if err != nil {
 		return err
 	}
+	sink, _, err := zap.Open("stdout")
+	if err != nil {
+		return err
+	}
+
+	level.SetLevel(logLevel.Level())
+	format = c.Format
+	gcpProject = c.GCPProjectID
+
+	core := newCore(zapcore.NewJSONEncoder(encoding), sink, c.SamplingInitial, c.SamplingThereafter)
 	defer Logger.Sync()
-	Logger = initLogger.Sugar()
+	Logger = zap.New(core, zap.AddCaller(), zap.ErrorOutput(sink)).Sugar()
+	return nil
+}
+
+// newCore writes entries at the shared level. Below warn, each message is logged at most initial times a second
+// and every thereafter-th time after that; warnings and errors are never sampled. Sampling is off if initial is 0.
+func newCore(encoder zapcore.Encoder, sink zapcore.WriteSyncer, initial int, thereafter int) zapcore.Core {
+	if initial <= 0 {
+		return zapcore.NewCore(encoder, sink, level)
+	}
+
+	verbose := zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+		return level.Enabled(l) && l < zapcore.WarnLevel
+	})
+	important := zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+		return level.Enabled(l) && l >= zapcore.WarnLevel
+	})
+	return zapcore.NewTee(
+		zapcore.NewSamplerWithOptions(zapcore.NewCore(encoder, sink, verbose), time.Second, initial, thereafter),
+		zapcore.NewCore(encoder.Clone(), sink, important),
+	)
+}
+
+// encoderConfig returns the field names for a log_format. "gcp" uses the severity names and special fields
+// understood by Google Cloud Logging.
+func encoderConfig(logFormat string) (zapcore.EncoderConfig, error) {
+	config := zapcore.EncoderConfig{
+		MessageKey: "message",
+
+		LevelKey:    "severity",
+		EncodeLevel: zapcore.CapitalLevelEncoder,
+
+		TimeKey:    "timestamp",
+		EncodeTime: zapcore.RFC3339NanoTimeEncoder,
+
+		CallerKey:    "caller",
+		EncodeCaller: zapcore.ShortCallerEncoder,
+	}
+	switch logFormat {
+	case "json":
+	case "gcp":
+		config.EncodeLevel = gcpSeverityEncoder
+	default:
+		return config, fmt.Errorf("log_format %q must be json or gcp", logFormat)
+	}
+	return config, nil
+}
+
+var gcpSeverities = map[zapcore.Level]string{
+	zapcore.DebugLevel:  "DEBUG",
+	zapcore.InfoLevel:   "INFO",
+	zapcore.WarnLevel:   "WARNING",
+	zapcore.ErrorLevel:  "ERROR",
+	zapcore.DPanicLevel: "CRITICAL",
+	zapcore.PanicLevel:  "ALERT",
+	zapcore.FatalLevel:  "EMERGENCY",
+}
+
+func gcpSeverityEncoder(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendString(gcpSeverities[l])
+}
+
+// LevelHandler reports the log level on GET and changes it on PUT, with a body such as {"level":"debug"}
+func LevelHandler() http.Handler {
+	return level
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l, which ForContext will return in place of Logger
+func NewContext(ctx context.Context, l *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// ForContext returns the logger stored in ctx by NewContext, or Logger if there isn't one, with the trace and span
+// IDs of any span in ctx, so log lines can be matched up with traces
+func ForContext(ctx context.Context) *zap.SugaredLogger {
+	log, ok := ctx.Value(contextKey{}).(*zap.SugaredLogger)
+	if !ok {
+		log = Logger
+	}
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return log
+	}
+	if format == "gcp" && gcpProject != "" {
+		return log.With(
+			"logging.googleapis.com/trace", "projects/"+gcpProject+"/traces/"+spanContext.TraceID().String(),
+			"logging.googleapis.com/spanId", spanContext.SpanID().String(),
+			"logging.googleapis.com/trace_sampled", spanContext.IsSampled(),
+		)
+	}
+	return log.With("trace_id", spanContext.TraceID().String(), "span_id", spanContext.SpanID().String())
+}
+
+// HTTPRequest describes a handled request for an access log line
+type HTTPRequest struct {
+	Method    string
+	URL       string
+	Route     string
+	Status    int
+	Latency   time.Duration
+	User      string
+	UserAgent string
+	RemoteIP  string
+}
+
+// Fields returns the request as key-value pairs for a SugaredLogger. In the gcp format the standard parts are nested
+// under httpRequest, which Cloud Logging shows as the request of the entry.
+func (h HTTPRequest) Fields() []interface{} {
+	if format == "gcp" {
+		return []interface{}{"httpRequest", gcpHTTPRequest(h), "route", h.Route, "user", h.User}
+	}
+	return []interface{}{
+		"method", h.Method,
+		"route", h.Route,
+		"status", h.Status,
+		"duration", h.Latency.Seconds(),
+		"user", h.User,
+	}
+}
+
+type gcpHTTPRequest HTTPRequest
+
+func (h gcpHTTPRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("requestMethod", h.Method)
+	enc.AddString("requestUrl", h.URL)
+	enc.AddInt("status", h.Status)
+	enc.AddString("latency", fmt.Sprintf("%.9fs", h.Latency.Seconds()))
+	enc.AddString("userAgent", h.UserAgent)
+	enc.AddString("remoteIp", h.RemoteIP)
 	return nil
 }
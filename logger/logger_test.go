@@ -1,11 +1,20 @@
 package logger
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestGetZapLevel(t *testing.T) {
@@ -22,3 +31,95 @@ func testGetZapLevel(levelString string, expectedLevel zapcore.Level) func(*test
 		assert.Equal(t, expectedLevel, level.Level())
 	}
 }
+
+func TestForContextAddsTraceFields(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	Logger = zap.New(core).Sugar()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+
+	ForContext(ctx).Info("traced")
+	ForContext(context.Background()).Info("untraced")
+
+	entries := logs.AllUntimed()
+	assert.Equal(t, map[string]interface{}{"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736", "span_id": "00f067aa0ba902b7"}, entries[0].ContextMap())
+	assert.Empty(t, entries[1].ContextMap())
+}
+
+func TestForContextReturnsStoredLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	Logger = zap.New(core).Sugar()
+
+	ctx := NewContext(context.Background(), Logger.With("request_id", "abc-123"))
+	ForContext(ctx).Info("in request")
+
+	entries := logs.AllUntimed()
+	assert.Equal(t, map[string]interface{}{"request_id": "abc-123"}, entries[0].ContextMap())
+}
+
+func TestLevelHandlerChangesLevelAtRuntime(t *testing.T) {
+	level.SetLevel(zap.InfoLevel)
+	defer level.SetLevel(zap.InfoLevel)
+
+	req := httptest.NewRequest("PUT", "/admin/loglevel", strings.NewReader(`{"level":"debug"}`))
+	resp := httptest.NewRecorder()
+	LevelHandler().ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, zap.DebugLevel, level.Level())
+}
+
+func TestSamplingOnlyAppliesBelowWarn(t *testing.T) {
+	level.SetLevel(zap.InfoLevel)
+	encoding, _ := encoderConfig("json")
+	var out bytes.Buffer
+	log := zap.New(newCore(zapcore.NewJSONEncoder(encoding), zapcore.AddSync(&out), 2, 1000)).Sugar()
+
+	for i := 0; i < 5; i++ {
+		log.Info("busy")
+		log.Warn("worrying")
+	}
+
+	assert.Equal(t, 2, strings.Count(out.String(), `"busy"`))
+	assert.Equal(t, 5, strings.Count(out.String(), `"worrying"`))
+}
+
+func TestGCPFormat(t *testing.T) {
+	assert.NoError(t, Config{Format: "gcp"}.Validate())
+	format, gcpProject = "gcp", "ras-rm-sandbox"
+	defer func() { format, gcpProject = "json", "" }()
+
+	encoding, _ := encoderConfig("gcp")
+	var out bytes.Buffer
+	Logger = zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoding), zapcore.AddSync(&out), zap.DebugLevel)).Sugar()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+
+	request := HTTPRequest{Method: "GET", URL: "/survey/141", Route: "/survey/{surveyRef}", Status: 200, Latency: 1500 * time.Millisecond}
+	ForContext(ctx).Warnw("Handled request", request.Fields()...)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatal("Error decoding log entry, ", err.Error())
+	}
+	assert.Equal(t, "WARNING", entry["severity"])
+	assert.Equal(t, "projects/ras-rm-sandbox/traces/4bf92f3577b34da6a3ce929d0e0e4736", entry["logging.googleapis.com/trace"])
+	assert.Equal(t, "00f067aa0ba902b7", entry["logging.googleapis.com/spanId"])
+	assert.Equal(t, "/survey/{surveyRef}", entry["route"])
+	assert.Equal(t, map[string]interface{}{
+		"requestMethod": "GET",
+		"requestUrl":    "/survey/141",
+		"status":        float64(200),
+		"latency":       "1.500000000s",
+		"userAgent":     "",
+		"remoteIp":      "",
+	}, entry["httpRequest"])
+}
+
+func TestValidateRejectsUnknownFormat(t *testing.T) {
+	assert.EqualError(t, Config{Level: "INFO", Format: "xml"}.Validate(), `log_format "xml" must be json or gcp`)
+}
@@ -1,60 +1,104 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"log"
-	"net/http"
+	"net"
+	"os"
 
 	"github.com/ONSdigital/ras-rm-survey/logger"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/gorilla/mux"
-	"github.com/spf13/viper"
 	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
 )
 
-var db *sql.DB
-
 func main() {
 	viper.AutomaticEnv()
 	setDefaults()
-	err := logger.ConfigureLogger()
+	args, err := parseFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	config, err = loadConfig()
 	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// check-config reports invalid settings itself, so fall back to the default logger for it
+	if err := logger.ConfigureLogger(config.Log); err != nil && (len(args) == 0 || args[0] != "check-config") {
 		log.Fatalln("Couldn't set up a logger, exiting", err)
 	}
 
+	if err = runCommand(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// serve runs any migrations, checks the schema version and then runs the HTTP server; it is the default command
+func serve() error {
 	logger.Logger.Info("Starting ras-rm-survey...")
 
-	dbURI := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=disable", viper.GetString("db_host"), viper.GetString("db_port"), viper.GetString("db_name"), viper.GetString("db_username"), viper.GetString("db_password"))
-	db, err = sql.Open("postgres", dbURI)
-	if err != nil {
+	if err := config.validate(); err != nil {
+		logger.Logger.Fatal(err.Error())
+	}
+	logger.Logger.Infow("Loaded configuration", "settings", config.settings())
+
+	if err := openDB(); err != nil {
 		logger.Logger.Fatal("Couldn't connect to postgres, " + err.Error())
 	}
 
-	dbMigrate()
+	if err := openFileStore(); err != nil {
+		logger.Logger.Fatal("Couldn't open instrument file storage, " + err.Error())
+	}
 
-	router := mux.NewRouter()
-	handleEndpoints(router)
-	logger.Logger.Info("ras-rm-survey started")
-	http.ListenAndServe(":8080", router)
-}
+	if config.DB.AutoMigrate {
+		if err := dbMigrate(); err != nil {
+			logger.Logger.Fatal("Database migration failed ", err)
+		}
+	} else {
+		logger.Logger.Info("Automatic migration disabled, expecting the schema to be migrated separately")
+	}
 
-func dbMigrate() {
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
-	if err != nil {
-		log.Fatal("Database connection for migration failed", err)
+	// Refuse to serve against a schema older than this binary understands
+	if err := checkSchemaVersion(context.Background()); err != nil {
+		logger.Logger.Fatal("Database schema isn't usable, " + err.Error())
 	}
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://db-migrations",
-		"postgres",
-		driver,
-	)
+
+	shutdownTracing, err := configureTracing(context.Background())
 	if err != nil {
-		log.Fatal("Database migration failed", err)
+		logger.Logger.Fatal("Couldn't set up tracing, " + err.Error())
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Logger.Warn("Couldn't flush traces ", err)
+		}
+	}()
+
+	if config.Transitions.Enabled {
+		registerWorker("collection exercise transitions", runTransitionWorker)
+	}
+	if err := openEventPublisher(); err != nil {
+		logger.Logger.Fatal(err.Error())
 	}
-	if err = m.Up(); err != nil && err != migrate.ErrNoChange {
-		log.Fatal("Database migration failed ", err)
+	if exerciseEvents != nil {
+		registerWorker("collection exercise event relay", runEventRelay)
 	}
-}
\ No newline at end of file
+
+	adminRouter := mux.NewRouter()
+	handleAdminEndpoints(adminRouter)
+	adminServer := newAdminServer(adminRouter)
+	adminListener, err := net.Listen("tcp", adminServer.Addr)
+	if err != nil {
+		logger.Logger.Fatal("Couldn't listen for admin requests, " + err.Error())
+	}
+	registerWorker("admin server", adminServerWorker(adminServer, adminListener))
+
+	router := mux.NewRouter()
+	handleEndpoints(router)
+	logger.Logger.Info("ras-rm-survey started")
+	return runServer(router)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const metricsNamespace = "ras_rm_survey"
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route template, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route template, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time taken by database operations, by operation and whether it succeeded.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"operation", "outcome"})
+
+	exerciseTransitionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "exercise_transitions_total",
+		Help:      "Collection exercises moved to a new state by the transition worker, by old and new state.",
+	}, []string{"from", "to"})
+
+	exerciseEventsPublishedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "exercise_events_published_total",
+		Help:      "Collection exercise events published by the event relay.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		httpRequestsTotal,
+		httpRequestDuration,
+		dbQueryDuration,
+		exerciseTransitionsTotal,
+		exerciseEventsPublishedTotal,
+		dbStatsCollector{},
+		exerciseStateCollector{},
+		buildInfoCollector{},
+	)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// instrumentRequests counts and times every request by its route template, so /survey/141 and /survey/062 are
+// reported together as /survey/{surveyRef}
+func instrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		labels := prometheus.Labels{"route": routeTemplate(r), "method": r.Method, "status": strconv.Itoa(recorder.status)}
+		httpRequestsTotal.With(labels).Inc()
+		httpRequestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// routeTemplate returns the path template of the matched route, or "unmatched"
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unmatched"
+}
+
+// observeQuery times and traces a database operation. Defer the returned function with a pointer to the caller's
+// named error result, so the outcome is known when it runs:
+//
+//	defer observeQuery(ctx, "find_surveys")(&err)
+func observeQuery(ctx context.Context, operation string) func(err *error) {
+	start := time.Now()
+	span := startQuerySpan(ctx, operation)
+	return func(err *error) {
+		outcome := "success"
+		if *err != nil {
+			outcome = "error"
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+		span.End()
+		dbQueryDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+	}
+}
+
+var (
+	dbOpenConnectionsDesc = prometheus.NewDesc(metricsNamespace+"_db_open_connections", "Open database connections, by whether they are in use or idle.", []string{"state"}, nil)
+	dbMaxOpenDesc         = prometheus.NewDesc(metricsNamespace+"_db_max_open_connections", "Maximum number of open database connections allowed.", nil, nil)
+	dbWaitCountDesc       = prometheus.NewDesc(metricsNamespace+"_db_wait_count_total", "Times a request waited for a free database connection.", nil, nil)
+	dbWaitDurationDesc    = prometheus.NewDesc(metricsNamespace+"_db_wait_duration_seconds_total", "Total time spent waiting for a free database connection.", nil, nil)
+	exercisesDesc         = prometheus.NewDesc(metricsNamespace+"_collection_exercises", "Collection exercises, by state.", []string{"state"}, nil)
+	buildInfoDesc         = prometheus.NewDesc(metricsNamespace+"_build_info", "Always 1, labelled with the version, commit and Go version of the running binary.", []string{"version", "git_commit", "go_version"}, nil)
+)
+
+// buildInfoCollector reports what's running, as GET /info does
+type buildInfoCollector struct{}
+
+func (buildInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- buildInfoDesc
+}
+
+func (buildInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(buildInfoDesc, prometheus.GaugeValue, 1, appVersion(), gitCommit, runtime.Version())
+}
+
+// dbStatsCollector reports the connection pool statistics of the current db
+type dbStatsCollector struct{}
+
+func (dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbOpenConnectionsDesc
+	ch <- dbMaxOpenDesc
+	ch <- dbWaitCountDesc
+	ch <- dbWaitDurationDesc
+}
+
+func (dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	if db == nil {
+		return
+	}
+	stats := db.Stats()
+	ch <- prometheus.MustNewConstMetric(dbOpenConnectionsDesc, prometheus.GaugeValue, float64(stats.InUse), "in_use")
+	ch <- prometheus.MustNewConstMetric(dbOpenConnectionsDesc, prometheus.GaugeValue, float64(stats.Idle), "idle")
+	ch <- prometheus.MustNewConstMetric(dbMaxOpenDesc, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbWaitCountDesc, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitDurationDesc, prometheus.CounterValue, stats.WaitDuration.Seconds())
+}
+
+// exerciseStateCollector counts collection exercises in each state when scraped
+type exerciseStateCollector struct{}
+
+func (exerciseStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- exercisesDesc
+}
+
+func (exerciseStateCollector) Collect(ch chan<- prometheus.Metric) {
+	if db == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	counts, err := countExercisesByState(ctx)
+	if err != nil {
+		logger.Logger.Warn("Couldn't count collection exercises for metrics ", err)
+		return
+	}
+	for state, count := range counts {
+		ch <- prometheus.MustNewConstMetric(exercisesDesc, prometheus.GaugeValue, float64(count), state)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var countExercisesQuery = "SELECT COALESCE\\(state, ''\\), count\\(\\*\\) FROM (.+)collection_exercise GROUP BY state"
+
+func TestMetricsEndpoint(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	returnRows := mock.NewRows(searchSurveyQueryColumns)
+	returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "SEFT")
+	mock.ExpectQuery(findSurveyQuery).WillReturnRows(returnRows)
+
+	req := httptest.NewRequest("GET", "/survey/123", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	exerciseRows := mock.NewRows([]string{"state", "count"})
+	exerciseRows.AddRow("LIVE", 2)
+	exerciseRows.AddRow("CREATED", 1)
+	mock.ExpectQuery(countExercisesQuery).WillReturnRows(exerciseRows)
+
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	metrics := string(body)
+
+	assert.Contains(t, metrics, `ras_rm_survey_http_requests_total{method="GET",route="/survey/{surveyRef}",status="200"}`)
+	assert.Contains(t, metrics, `ras_rm_survey_http_request_duration_seconds_count{method="GET",route="/survey/{surveyRef}",status="200"}`)
+	assert.Contains(t, metrics, `ras_rm_survey_db_query_duration_seconds_count{operation="find_surveys",outcome="success"}`)
+	assert.Contains(t, metrics, `ras_rm_survey_db_open_connections{state="idle"}`)
+	assert.Contains(t, metrics, `ras_rm_survey_collection_exercises{state="LIVE"} 2`)
+	assert.Contains(t, metrics, `ras_rm_survey_collection_exercises{state="CREATED"} 1`)
+	assert.Contains(t, metrics, `ras_rm_survey_build_info{git_commit="`+gitCommit+`",go_version="`+runtime.Version()+`",version="`+appVersion()+`"} 1`)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+	"github.com/gofrs/uuid"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// validRequestID is what a caller's X-Request-ID must look like for it to be used, so that it can't break up log lines
+// or fill them
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// instrumentFileRoutes upload or download SEFT files, which can take far longer than any other request
+var instrumentFileRoutes = map[string]bool{
+	"/survey/{surveyRef}/collectioninstrument": true,
+	"/collectioninstrument/{uuid}/file":        true,
+}
+
+// requestTimeout gives every request a deadline, which database calls made with the request context respect. Requests
+// to upload or download a SEFT file get instrument_file_timeout instead, to read and write their connection as well.
+func requestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := config.HTTP.RequestTimeout
+		if instrumentFileRoutes[routeTemplate(r)] {
+			timeout = config.Instruments.FileTimeout
+			extendConnDeadlines(r, timeout)
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// logRequests writes an access log line for every request. It takes the correlation ID from the X-Request-ID header,
+// generating one if the caller didn't send a valid one, echoes it in the response and stores a logger carrying it in the
+// request context for handlers to use through logger.ForContext.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		ctx = logger.NewContext(ctx, logger.Logger.With("request_id", requestID))
+		r = r.WithContext(ctx)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		user, _, _ := r.BasicAuth()
+		access := logger.HTTPRequest{
+			Method:    r.Method,
+			URL:       r.URL.RequestURI(),
+			Route:     routeTemplate(r),
+			Status:    recorder.status,
+			Latency:   time.Since(start),
+			User:      user,
+			UserAgent: r.UserAgent(),
+			RemoteIP:  r.RemoteAddr,
+		}
+		logger.ForContext(ctx).Infow("Handled request", access.Fields()...)
+	})
+}
+
+func newRequestID() string {
+	id, err := uuid.NewV4()
+	if err != nil {
+		logger.Logger.Warn("Couldn't generate a request ID ", err)
+		return ""
+	}
+	return id.String()
+}
+
+// requestIDFromContext returns the correlation ID set by logRequests, or "" outside a request
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestIDIsGeneratedWhenMissing(t *testing.T) {
+	setup()
+
+	req := httptest.NewRequest("GET", "/info", nil)
+	router.ServeHTTP(resp, req)
+
+	_, err := uuid.FromString(resp.Header().Get("X-Request-ID"))
+	assert.NoError(t, err, "generated request ID should be a UUID")
+}
+
+func TestRequestIDIsReplacedWhenInvalid(t *testing.T) {
+	for _, requestID := range []string{"abc 123", "abc\nlevel=error", "<script>", strings.Repeat("a", 129)} {
+		setup()
+
+		req := httptest.NewRequest("GET", "/info", nil)
+		req.Header.Set("X-Request-ID", requestID)
+		router.ServeHTTP(resp, req)
+
+		_, err := uuid.FromString(resp.Header().Get("X-Request-ID"))
+		assert.NoError(t, err, "%q should be replaced by a generated UUID", requestID)
+	}
+}
+
+func TestRequestIDIsKeptWhenValid(t *testing.T) {
+	setup()
+
+	requestID := "Trace_1.2-" + strings.Repeat("a", 118)
+	req := httptest.NewRequest("GET", "/info", nil)
+	req.Header.Set("X-Request-ID", requestID)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, requestID, resp.Header().Get("X-Request-ID"))
+}
+
+func TestRequestIDIsReturnedInErrorBodies(t *testing.T) {
+	setup()
+	db = nil
+
+	req := httptest.NewRequest("GET", "/survey?shortName=TS", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	assert.Equal(t, "abc-123", resp.Header().Get("X-Request-ID"))
+
+	var restError models.RESTError
+	err := json.NewDecoder(resp.Body).Decode(&restError)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /survey', ", err.Error())
+	}
+	assert.Equal(t, "DATABASE_UNAVAILABLE", restError.Code)
+	assert.Equal(t, "abc-123", restError.RequestID)
+}
+
+func TestRequestsAreLoggedWithRequestID(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	defer func(previous *zap.SugaredLogger) { logger.Logger = previous }(logger.Logger)
+	logger.Logger = zap.New(core).Sugar()
+
+	setup()
+	db = nil
+
+	req := httptest.NewRequest("GET", "/survey/123", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	req.SetBasicAuth("admin", "secret")
+	router.ServeHTTP(resp, req)
+
+	handlerError := logs.FilterMessage("Database connection could not be found").AllUntimed()
+	if assert.Len(t, handlerError, 1) {
+		assert.Equal(t, "abc-123", handlerError[0].ContextMap()["request_id"])
+	}
+
+	accessLog := logs.FilterMessage("Handled request").AllUntimed()
+	if assert.Len(t, accessLog, 1) {
+		fields := accessLog[0].ContextMap()
+		assert.Equal(t, "abc-123", fields["request_id"])
+		assert.Equal(t, "GET", fields["method"])
+		assert.Equal(t, "/survey/{surveyRef}", fields["route"])
+		assert.Equal(t, int64(http.StatusInternalServerError), fields["status"])
+		assert.Equal(t, "admin", fields["user"])
+		assert.Contains(t, fields, "duration")
+	}
+}
+
+func TestRequestTimeoutIsInstrumentFileTimeoutForFiles(t *testing.T) {
+	config = defaultConfig()
+
+	timeouts := map[string]time.Duration{}
+	r := mux.NewRouter()
+	r.Use(requestTimeout)
+	recordTimeout := func(w http.ResponseWriter, req *http.Request) {
+		deadline, _ := req.Context().Deadline()
+		timeouts[req.URL.Path] = time.Until(deadline)
+	}
+	r.HandleFunc("/collectioninstrument/{uuid}/file", recordTimeout)
+	r.HandleFunc("/collectioninstrument", recordTimeout)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/collectioninstrument/ddc37cb6-c88a-473b-949a-fa5fad9265a1/file", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/collectioninstrument", nil))
+
+	assert.InDelta(t, config.Instruments.FileTimeout, timeouts["/collectioninstrument/ddc37cb6-c88a-473b-949a-fa5fad9265a1/file"], float64(time.Second))
+	assert.InDelta(t, config.HTTP.RequestTimeout, timeouts["/collectioninstrument"], float64(time.Second))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"text/template"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const migrationsDir = "db-migrations"
+
+// newMigrate returns a migrator for the db-migrations directory. The postgres driver keeps a connection for as long
+// as it's open, so it gets a database handle of its own rather than a connection from the shared pool; Close the
+// migrator when done with it to release that connection.
+func newMigrate() (*migrate.Migrate, error) {
+	src, err := newTemplatedSource("file://"+migrationsDir, config.DB.Schema)
+	if err != nil {
+		return nil, err
+	}
+	migrationDB, err := sql.Open("postgres", dataSourceName())
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	driver, err := postgres.WithInstance(migrationDB, &postgres.Config{MigrationsTable: migrationsTable()})
+	if err != nil {
+		src.Close()
+		migrationDB.Close()
+		return nil, err
+	}
+	m, err := migrate.NewWithInstance("templated", src, "postgres", driver)
+	if err != nil {
+		src.Close()
+		driver.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// migrationsTable records the migration version of the configured schema. The default schema keeps golang-migrate's
+// schema_migrations table, which existing databases already have; any other schema has its own so that several
+// environments or test runs can share one database.
+func migrationsTable() string {
+	if config.DB.Schema == defaultSchema {
+		return postgres.DefaultMigrationsTable
+	}
+	return config.DB.Schema + "_" + postgres.DefaultMigrationsTable
+}
+
+// templatedSource renders each migration as a text/template, so that migrations can refer to {{ .Schema }}
+// rather than hard-coding the schema name
+type templatedSource struct {
+	source.Driver
+	data migrationTemplateData
+}
+
+type migrationTemplateData struct {
+	Schema string
+}
+
+func newTemplatedSource(url string, schema string) (source.Driver, error) {
+	if err := validateSchemaName(schema); err != nil {
+		return nil, err
+	}
+	driver, err := source.Open(url)
+	if err != nil {
+		return nil, err
+	}
+	return &templatedSource{Driver: driver, data: migrationTemplateData{Schema: schema}}, nil
+}
+
+func (s *templatedSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	r, identifier, err := s.Driver.ReadUp(version)
+	if err != nil {
+		return nil, identifier, err
+	}
+	rendered, err := s.render(identifier, r)
+	return rendered, identifier, err
+}
+
+func (s *templatedSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	r, identifier, err := s.Driver.ReadDown(version)
+	if err != nil {
+		return nil, identifier, err
+	}
+	rendered, err := s.render(identifier, r)
+	return rendered, identifier, err
+}
+
+func (s *templatedSource) render(identifier string, r io.ReadCloser) (io.ReadCloser, error) {
+	defer r.Close()
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(identifier).Option("missingkey=error").Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse migration %s: %w", identifier, err)
+	}
+	var rendered bytes.Buffer
+	if err = tmpl.Execute(&rendered, s.data); err != nil {
+		return nil, fmt.Errorf("couldn't render migration %s: %w", identifier, err)
+	}
+	return ioutil.NopCloser(&rendered), nil
+}
+
+// dbMigrate applies any outstanding migrations while holding the migration lock, so only one replica migrates at a time
+func dbMigrate() error {
+	return withMigrationLock(context.Background(), func() error {
+		m, err := newMigrate()
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+		if err = m.Up(); err != nil && err != migrate.ErrNoChange {
+			return err
+		}
+		return nil
+	})
+}
+
+// migrationLockKey is the Postgres advisory lock key guarding migrations of the configured schema
+func migrationLockKey() int64 {
+	return int64(crc32.ChecksumIEEE([]byte("ras-rm-survey:" + config.DB.Schema)))
+}
+
+// withMigrationLock runs fn while holding a session-level Postgres advisory lock. Any other replica trying to
+// migrate at the same time blocks until fn has finished, then finds nothing left to do.
+func withMigrationLock(ctx context.Context, fn func() error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't get a connection for the migration lock: %w", err)
+	}
+	defer conn.Close()
+
+	key := migrationLockKey()
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		return fmt.Errorf("couldn't take the migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			logger.Logger.Warn("Couldn't release the migration lock ", err)
+		}
+	}()
+
+	return fn()
+}
+
+// expectedSchemaVersion is the newest migration shipped with this binary
+func expectedSchemaVersion() (uint, error) {
+	files, err := ioutil.ReadDir(migrationsDir)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't list migrations: %w", err)
+	}
+
+	var latest uint
+	for _, file := range files {
+		migration, err := source.Parse(file.Name())
+		if err != nil {
+			continue
+		}
+		if migration.Version > latest {
+			latest = migration.Version
+		}
+	}
+	return latest, nil
+}
+
+// schemaVersion reads the applied migration version and dirty flag straight from the migrations table
+func schemaVersion(ctx context.Context) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	err := db.QueryRowContext(ctx, "SELECT version, dirty FROM "+migrationsTable()+" LIMIT 1").Scan(&version, &dirty)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("couldn't read the schema version: %w", err)
+	}
+	return uint(version), dirty, nil
+}
+
+// checkSchemaVersion returns an error if the database schema isn't at least the version this binary expects
+func checkSchemaVersion(ctx context.Context) error {
+	expected, err := expectedSchemaVersion()
+	if err != nil {
+		return err
+	}
+	version, dirty, err := schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty, a migration failed part way through and needs fixing with 'migrate force'", version)
+	}
+	if version < expected {
+		return fmt.Errorf("schema version %d is behind the expected version %d, run 'migrate up'", version, expected)
+	}
+	if version > expected {
+		logger.Logger.Warnf("Schema version %d is ahead of the expected version %d", version, expected)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var schemaVersionQuery = "SELECT version, dirty FROM schema_migrations"
+var schemaVersionColumns = []string{"version", "dirty"}
+
+func setupMigrate(t *testing.T) sqlmock.Sqlmock {
+	config = defaultConfig()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+	return mock
+}
+
+// latestSchemaVersion is the version a migrated schema is at, so tests don't need changing when a migration is added
+func latestSchemaVersion(t *testing.T) uint {
+	version, err := expectedSchemaVersion()
+	if err != nil {
+		t.Fatal("Error finding the latest migration, ", err.Error())
+	}
+	return version
+}
+
+func TestExpectedSchemaVersionIsLatestMigration(t *testing.T) {
+	// Migrations are numbered from 1 without gaps, so the latest is the number of them
+	ups, err := filepath.Glob(filepath.Join(migrationsDir, "*.up.sql"))
+	assert.NoError(t, err)
+
+	version, err := expectedSchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(len(ups)), version)
+}
+
+func TestCheckSchemaVersionPassesWhenUpToDate(t *testing.T) {
+	mock := setupMigrate(t)
+
+	mock.ExpectQuery(schemaVersionQuery).WillReturnRows(mock.NewRows(schemaVersionColumns).AddRow(latestSchemaVersion(t), false))
+
+	assert.NoError(t, checkSchemaVersion(context.Background()))
+}
+
+func TestCheckSchemaVersionFailsWhenBehind(t *testing.T) {
+	mock := setupMigrate(t)
+
+	mock.ExpectQuery(schemaVersionQuery).WillReturnRows(mock.NewRows(schemaVersionColumns))
+
+	err := checkSchemaVersion(context.Background())
+	assert.EqualError(t, err, fmt.Sprintf("schema version 0 is behind the expected version %d, run 'migrate up'", latestSchemaVersion(t)))
+}
+
+func TestCheckSchemaVersionFailsWhenDirty(t *testing.T) {
+	mock := setupMigrate(t)
+
+	mock.ExpectQuery(schemaVersionQuery).WillReturnRows(mock.NewRows(schemaVersionColumns).AddRow(1, true))
+
+	err := checkSchemaVersion(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "dirty")
+}
+
+func TestWithMigrationLockReleasesLockAfterFailure(t *testing.T) {
+	mock := setupMigrate(t)
+
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockKey()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockKey()).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	migrationErr := errors.New("migration failed")
+	err := withMigrationLock(context.Background(), func() error { return migrationErr })
+
+	assert.Equal(t, migrationErr, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTemplatedSourceRendersSchemaName(t *testing.T) {
+	src, err := newTemplatedSource("file://"+migrationsDir, "test_run_1")
+	if err != nil {
+		t.Fatal("Error opening the migration source, ", err.Error())
+	}
+
+	r, _, err := src.ReadUp(1)
+	if err != nil {
+		t.Fatal("Error reading migration 1, ", err.Error())
+	}
+	up, _ := ioutil.ReadAll(r)
+	assert.Contains(t, string(up), "CREATE SCHEMA IF NOT EXISTS test_run_1;")
+	assert.NotContains(t, string(up), "surveyv2")
+
+	r, _, err = src.ReadDown(1)
+	if err != nil {
+		t.Fatal("Error reading migration 1 down, ", err.Error())
+	}
+	down, _ := ioutil.ReadAll(r)
+	assert.Contains(t, string(down), "DROP SCHEMA IF EXISTS test_run_1;")
+	assert.NotContains(t, string(down), "CASCADE")
+}
+
+func TestTemplatedSourceRejectsUnsafeSchemaName(t *testing.T) {
+	_, err := newTemplatedSource("file://"+migrationsDir, "surveyv2; DROP TABLE survey")
+	assert.Error(t, err)
+}
+
+func TestValidateSchemaName(t *testing.T) {
+	assert.NoError(t, validateSchemaName("surveyv2"))
+	assert.NoError(t, validateSchemaName("_test_run_42"))
+	assert.Error(t, validateSchemaName(""))
+	assert.Error(t, validateSchemaName("1survey"))
+	assert.Error(t, validateSchemaName("Survey"))
+	assert.Error(t, validateSchemaName("survey.v2"))
+	assert.Error(t, validateSchemaName("survey\"; DROP SCHEMA public; --"))
+}
+
+func TestMigrationsTableIsUnchangedForTheDefaultSchema(t *testing.T) {
+	config = defaultConfig()
+	assert.Equal(t, "schema_migrations", migrationsTable())
+
+	config.DB.Schema = "test_run_1"
+	assert.Equal(t, "test_run_1_schema_migrations", migrationsTable())
+}
@@ -1,36 +1,201 @@
 package models
 
+import "time"
+
+// Values of Health.Status and ComponentHealth.Status
+const (
+	StatusUp   = "UP"
+	StatusDown = "DOWN"
+)
+
+// Values of TimelineEvent.Type
+const (
+	EventTypeDate  = "DATE"
+	EventTypeEmail = "EMAIL"
+)
+
+// Codes used in the code field of RESTError
+const (
+	CodeConflict             = "CONFLICT"
+	CodeDatabaseUnavailable  = "DATABASE_UNAVAILABLE"
+	CodeExerciseExists       = "COLLECTION_EXERCISE_EXISTS"
+	CodeExerciseNotFound     = "COLLECTION_EXERCISE_NOT_FOUND"
+	CodeFileTooLarge         = "FILE_TOO_LARGE"
+	CodeInstrumentNotFound   = "COLLECTION_INSTRUMENT_NOT_FOUND"
+	CodeInternalError        = "INTERNAL_ERROR"
+	CodeInvalidExerciseDates = "INVALID_EXERCISE_DATES"
+	CodeInvalidFile          = "INVALID_FILE"
+	CodeInvalidJSON          = "INVALID_JSON"
+	CodeInvalidParameter     = "INVALID_QUERY_PARAMETER"
+	CodeInvalidRequest       = "INVALID_REQUEST"
+	CodeNoExerciseSchedule   = "NO_EXERCISE_SCHEDULE"
+	CodeNoValuesToUpdate     = "NO_VALUES_TO_UPDATE"
+	CodeRequestCancelled     = "REQUEST_CANCELLED"
+	CodeRequestTimeout       = "REQUEST_TIMEOUT"
+	CodeSurveyNotFound       = "SURVEY_NOT_FOUND"
+)
+
 type (
 	// Info represents the return values for GET /info
 	Info struct {
-		Name       string `json:"name"`
-		AppVersion string `json:"appVersion"`
+		Name          string   `json:"name"`
+		Version       string   `json:"version"`
+		GitCommit     string   `json:"gitCommit"`
+		BuildTime     string   `json:"buildTime"`
+		GoVersion     string   `json:"goVersion"`
+		SchemaVersion *uint    `json:"schemaVersion,omitempty"`
+		SchemaDirty   *bool    `json:"schemaDirty,omitempty"`
+		Features      []string `json:"features"`
 	}
 
-	// Health represents the return values for GET /health
+	// Health represents the return values for GET /health, /health/live and /health/ready
 	Health struct {
-		Database string `json:"database"`
-		RabbitMQ string `json:"rabbitmq"`
+		Status     string                     `json:"status,omitempty"`
+		Database   string                     `json:"database,omitempty"`
+		RabbitMQ   string                     `json:"rabbitmq,omitempty"`
+		Components map[string]ComponentHealth `json:"components,omitempty"`
 	}
 
-    // Error represents any erroneous response
-    Error struct {
-        Error string `json:"error"`
-    }
+	// ComponentHealth is the result of checking one dependency for GET /health/ready
+	ComponentHealth struct {
+		Status  string `json:"status"`
+		Latency string `json:"latency"`
+		Error   string `json:"error,omitempty"`
+	}
 
 	Survey struct {
 	    ID                      string      `json:"id"`
-        SurveyRef               string      `json:"surveyRef"`
+        SurveyRef               string      `json:"reference"`
         ShortName               string      `json:"shortName"`
         LongName                string      `json:"longName"`
         LegalBasis              string      `json:"legalBasis"`
         SurveyMode              string      `json:"surveyMode"`
-    //    CollectionInstruments   []string    `json:"collectionInstruments"`  //This is a placeholder until CIs are integrated
     }
 
+	// Surveys is the body of GET /survey
+	Surveys struct {
+		Data []SurveyWithInstruments `json:"data"`
+	}
+
+	// SurveyWithInstruments is a survey as returned by a search, with its collection instruments if include=instruments
+	SurveyWithInstruments struct {
+		Survey                Survey                 `json:"survey"`
+		CollectionInstruments []CollectionInstrument `json:"collectionInstruments,omitempty"`
+	}
+
+    // RESTError is the body of every error response
     RESTError struct {
     	Code      string `json:"code"`
     	Message   string `json:"message"`
     	Timestamp string `json:"timestamp"`
+    	RequestID string `json:"requestId,omitempty"`
     }
-)
\ No newline at end of file
+
+	// ExerciseExistsError is the body of a 409 from creating a collection exercise for a period its survey already has
+	ExerciseExistsError struct {
+		RESTError
+		ExerciseUUID string `json:"exerciseUUID"`
+	}
+
+	// CollectionExercise is a collection exercise on its own, as returned without verbose=true. Dates that haven't
+	// been set are left out.
+	CollectionExercise struct {
+		ExerciseUUID    string     `json:"exerciseUUID"`
+		SurveyReference string     `json:"surveyReference"`
+		State           string     `json:"state,omitempty"`
+		PeriodName      string     `json:"periodName,omitempty"`
+		MPS             *time.Time `json:"mps,omitempty"`
+		GoLive          *time.Time `json:"goLive,omitempty"`
+		PeriodStart     *time.Time `json:"periodStart,omitempty"`
+		PeriodEnd       *time.Time `json:"periodEnd,omitempty"`
+		Employment      *time.Time `json:"employment,omitempty"`
+		Return          *time.Time `json:"return,omitempty"`
+	}
+
+	// CloneExercise is the body of POST /collectionexercise/{uuid}/clone. The copy's dates and emails are moved on by
+	// ShiftMonths and then ShiftDays, either of which can be negative.
+	CloneExercise struct {
+		PeriodName  string `json:"periodName"`
+		ShiftMonths int    `json:"shiftMonths"`
+		ShiftDays   int    `json:"shiftDays"`
+	}
+
+	// GeneratedExercises is the result of generating a survey's collection exercises from its schedule: the exercises
+	// created, and the names of the periods skipped because the survey already had them
+	GeneratedExercises struct {
+		Created []CollectionExercise `json:"created"`
+		Skipped []string             `json:"skipped"`
+	}
+
+	// CollectionInstruments is the result of searching for collection instruments
+	CollectionInstruments struct {
+		Data []CollectionInstrument `json:"data"`
+	}
+
+	// CollectionExerciseDetail is a collection exercise with its survey and linked instruments, as returned with
+	// verbose=true
+	CollectionExerciseDetail struct {
+		Survey                Survey                 `json:"survey"`
+		CollectionInstruments []CollectionInstrument `json:"collectionInstruments"`
+		CollectionExercise    CollectionExercise     `json:"collectionExercise"`
+	}
+
+	// CollectionExercises is the body of GET /collectionexercise
+	CollectionExercises struct {
+		Data []CollectionExercise `json:"data"`
+	}
+
+	// CollectionExerciseDetails is the body of GET /collectionexercise?verbose=true
+	CollectionExerciseDetails struct {
+		Data []CollectionExerciseDetail `json:"data"`
+	}
+
+	// CollectionInstrument is an EQ or SEFT instrument belonging to a survey. Classifiers are usually strings, but
+	// can be any JSON value.
+	CollectionInstrument struct {
+		InstrumentUUID string                 `json:"instrumentUUID"`
+		InstrumentType string                 `json:"instrumentType,omitempty"`
+		Classifiers    map[string]interface{} `json:"classifiers,omitempty"`
+		SEFTFilename   string                 `json:"seftFilename,omitempty"`
+	}
+
+	// Timeline is the body of GET /collectionexercise/{uuid}/timeline
+	Timeline struct {
+		ExerciseUUID string          `json:"exerciseUUID"`
+		Events       []TimelineEvent `json:"events"`
+		Problems     []string        `json:"problems,omitempty"`
+	}
+
+	// TimelineEvent is one of a collection exercise's dates, named by its column, or a scheduled email, named by its type
+	TimelineEvent struct {
+		Type string    `json:"type"`
+		Name string    `json:"name"`
+		Time time.Time `json:"time"`
+	}
+
+	// Transition is a collection exercise moving to a new state because a date has passed
+	Transition struct {
+		ExerciseUUID    string    `json:"exerciseUUID"`
+		SurveyReference string    `json:"surveyReference"`
+		PeriodName      string    `json:"periodName"`
+		From            string    `json:"from"`
+		To              string    `json:"to"`
+		Due             time.Time `json:"due"`
+	}
+
+	// ExerciseEvent is a recorded change to a collection exercise, as published to the services that act on it
+	ExerciseEvent struct {
+		EventID      int64     `json:"eventID"`
+		ExerciseUUID string    `json:"exerciseUUID"`
+		EventType    string    `json:"eventType"`
+		FromState    string    `json:"fromState,omitempty"`
+		ToState      string    `json:"toState"`
+		OccurredAt   time.Time `json:"occurredAt"`
+	}
+
+	// PendingTransitions is the body of GET /admin/transitions
+	PendingTransitions struct {
+		At          time.Time    `json:"at"`
+		Transitions []Transition `json:"transitions"`
+	}
+)
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupPostgres points db at the Postgres named by TEST_DB_HOST, migrated into a schema of its own that's dropped when
+// the test ends, so the SQL sqlmock can't check is run for real. The test is skipped if TEST_DB_HOST isn't set.
+func setupPostgres(t *testing.T) {
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("TEST_DB_HOST isn't set")
+	}
+
+	config = defaultConfig()
+	config.DB.Host = host
+	if port, err := strconv.Atoi(os.Getenv("TEST_DB_PORT")); err == nil {
+		config.DB.Port = port
+	}
+	if name := os.Getenv("TEST_DB_NAME"); name != "" {
+		config.DB.Name = name
+	}
+	if username := os.Getenv("TEST_DB_USERNAME"); username != "" {
+		config.DB.Username = username
+	}
+	if password := os.Getenv("TEST_DB_PASSWORD"); password != "" {
+		config.DB.Password = Secret(password)
+	}
+	config.DB.SSLMode = "disable"
+	config.DB.ConnectAttempts = 1
+	config.DB.Schema = fmt.Sprintf("test_run_%d", time.Now().UnixNano())
+
+	if err := openDB(); err != nil {
+		t.Fatal("Error connecting to the test database, ", err.Error())
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + config.DB.Schema + " CASCADE")
+		db.Exec("DROP TABLE " + migrationsTable())
+		db.Close()
+		db = nil
+	})
+	if err := dbMigrate(); err != nil {
+		t.Fatal("Error migrating the test database, ", err.Error())
+	}
+}
+
+// execPostgres runs setup statements against the test schema, failing the test if one doesn't work
+func execPostgres(t *testing.T, statements ...string) {
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Error running %q, %s", statement, err.Error())
+		}
+	}
+}
+
+func TestCloneExerciseOnPostgres(t *testing.T) {
+	setupPostgres(t)
+	execPostgres(t,
+		"INSERT INTO "+schemaTable("survey")+" VALUES ('a0a5ffc9-5bd5-4ba6-a4cf-e2a1c2a1d1f3', '141', 'ASHE', 'Annual Survey of Hours and Earnings', 'Statistics of Trade Act 1947', 'SEFT')",
+		"INSERT INTO "+schemaTable("collection_exercise")+" (survey_ref, state, exercise_uuid, period_name, mps, go_live, period_start, period_end, return)"+
+			" VALUES ('141', 'LIVE', '6f1bf642-2f9c-408f-8ffe-93b40667d99a', '202009', '2020-08-21', '2020-09-01 09:00', '2020-09-01', '2020-09-30', '2020-10-14')",
+		"INSERT INTO "+schemaTable("email")+" (exercise_id, type, time_scheduled) VALUES (1, 'reminder', '2020-09-07 09:00')",
+	)
+
+	clone, err := cloneExercise(context.Background(), "6f1bf642-2f9c-408f-8ffe-93b40667d99a", models.CloneExercise{PeriodName: "202010", ShiftMonths: 1})
+	if err != nil {
+		t.Fatal("Error cloning the exercise, ", err.Error())
+	}
+
+	assert.Equal(t, "CREATED", clone.State)
+	assert.Equal(t, "202010", clone.PeriodName)
+	assert.Equal(t, "2020-10-31", clone.PeriodEnd.Format("2006-01-02"))
+	assert.Equal(t, "2020-11-14", clone.Return.Format("2006-01-02"))
+
+	var emails int
+	err = db.QueryRow("SELECT count(*) FROM "+schemaTable("email")+" e JOIN "+schemaTable("collection_exercise")+
+		" ce ON ce.exercise_id = e.exercise_id WHERE ce.exercise_uuid = $1 AND e.time_scheduled = '2020-10-07 09:00'", clone.ExerciseUUID).Scan(&emails)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, emails)
+
+	_, err = cloneExercise(context.Background(), "6f1bf642-2f9c-408f-8ffe-93b40667d99a", models.CloneExercise{PeriodName: "202010", ShiftMonths: 1})
+	assert.IsType(t, &exerciseExistsError{}, err)
+}
+
+func TestTransitionEventsArePublishedOnPostgres(t *testing.T) {
+	setupPostgres(t)
+	publisher := &recordingPublisher{}
+	exerciseEvents = publisher
+	t.Cleanup(func() { exerciseEvents = nil })
+	execPostgres(t,
+		"INSERT INTO "+schemaTable("survey")+" VALUES ('a0a5ffc9-5bd5-4ba6-a4cf-e2a1c2a1d1f3', '141', 'ASHE', 'Annual Survey of Hours and Earnings', 'Statistics of Trade Act 1947', 'SEFT')",
+		"INSERT INTO "+schemaTable("collection_exercise")+" (survey_ref, state, exercise_uuid, period_name, go_live, period_end)"+
+			" VALUES ('141', 'READY_FOR_LIVE', '6f1bf642-2f9c-408f-8ffe-93b40667d99a', '202009', '2020-09-01 09:00', '2020-09-30')",
+	)
+
+	transitions, err := applyDueTransitions(context.Background(), time.Date(2020, 9, 1, 9, 30, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Len(t, transitions, 1)
+
+	published, err := publishEvents(context.Background(), 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, "LIVE", publisher.events[0].ToState)
+
+	published, err = publishEvents(context.Background(), 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, published, "events are only published once")
+}
+
+func TestTransitionsAreTimedInUTCWhateverTheSessionTimeZone(t *testing.T) {
+	setupPostgres(t)
+	// One connection, so the time zone applies to every statement
+	db.SetMaxOpenConns(1)
+	execPostgres(t,
+		"SET TIME ZONE 'America/New_York'",
+		"INSERT INTO "+schemaTable("survey")+" VALUES ('a0a5ffc9-5bd5-4ba6-a4cf-e2a1c2a1d1f3', '141', 'ASHE', 'Annual Survey of Hours and Earnings', 'Statistics of Trade Act 1947', 'SEFT')",
+		"INSERT INTO "+schemaTable("collection_exercise")+" (survey_ref, state, exercise_uuid, period_name, go_live, period_end)"+
+			" VALUES ('141', 'READY_FOR_LIVE', '6f1bf642-2f9c-408f-8ffe-93b40667d99a', '202009', '2020-09-01 09:00', '2020-09-30')",
+	)
+
+	transitions, err := applyDueTransitions(context.Background(), time.Date(2020, 9, 1, 8, 30, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Empty(t, transitions, "go_live is still half an hour away")
+
+	now := time.Date(2020, 9, 1, 9, 30, 0, 0, time.UTC)
+	transitions, err = applyDueTransitions(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Len(t, transitions, 1)
+
+	var occurredAt time.Time
+	err = db.QueryRow("SELECT occurred_at FROM " + schemaTable("exercise_event")).Scan(&occurredAt)
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(occurredAt), "occurred at %s, not %s", occurredAt, now)
+}
+
+func TestTransitionExerciseRecordsAnEventOnPostgres(t *testing.T) {
+	setupPostgres(t)
+	execPostgres(t,
+		"INSERT INTO "+schemaTable("survey")+" VALUES ('a0a5ffc9-5bd5-4ba6-a4cf-e2a1c2a1d1f3', '141', 'ASHE', 'Annual Survey of Hours and Earnings', 'Statistics of Trade Act 1947', 'SEFT')",
+		"INSERT INTO "+schemaTable("collection_exercise")+" (survey_ref, state, exercise_uuid, period_name)"+
+			" VALUES ('141', 'READY_FOR_LIVE', '6f1bf642-2f9c-408f-8ffe-93b40667d99a', '202009')",
+	)
+
+	assert.NoError(t, transitionExercise(context.Background(), "6f1bf642-2f9c-408f-8ffe-93b40667d99a", "LIVE"))
+	assert.ErrorIs(t, transitionExercise(context.Background(), "6f1bf642-2f9c-408f-8ffe-93b40667d99a", "CREATED"), errInvalidTransition)
+
+	var from, to string
+	err := db.QueryRow("SELECT from_state, to_state FROM "+schemaTable("exercise_event")).Scan(&from, &to)
+	assert.NoError(t, err)
+	assert.Equal(t, "READY_FOR_LIVE", from)
+	assert.Equal(t, "LIVE", to)
+}
@@ -0,0 +1,849 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+)
+
+// The data access functions in this file are shared by the HTTP handlers and the command-line interface,
+// so both always run the same SQL against the same schema.
+
+var (
+	errSurveyNotFound     = errors.New("survey reference not found")
+	errExerciseNotFound   = errors.New("collection exercise not found")
+	errInstrumentNotFound = errors.New("collection instrument not found")
+	errInvalidState       = errors.New("invalid collection exercise state")
+	errInvalidTransition  = errors.New("collection exercise can't move to that state")
+)
+
+// exerciseSurveyPeriodKey is the unique constraint allowing a survey one collection exercise per period
+const exerciseSurveyPeriodKey = "collection_exercise_survey_period_key"
+
+// exerciseExistsError is returned when creating a collection exercise for a period its survey already has one for
+type exerciseExistsError struct {
+	exerciseUUID string
+}
+
+func (e *exerciseExistsError) Error() string {
+	return "collection exercise " + e.exerciseUUID + " already exists for that survey and period"
+}
+
+// exerciseDatesError is returned when a collection exercise's dates break its survey's rules
+type exerciseDatesError struct {
+	problems []string
+}
+
+func (e *exerciseDatesError) Error() string {
+	return "Invalid collection exercise dates: " + strings.Join(e.problems, "; ")
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a duplicate of the given unique constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == constraint
+}
+
+// surveySearchColumns maps the supported survey search parameters onto their database columns
+var surveySearchColumns = map[string]string{
+	"reference": "survey_ref",
+	"shortName": "short_name",
+	"longName":  "long_name",
+}
+
+// exerciseStates are the states a collection exercise can be in, as listed in openapi.yaml
+var exerciseStates = []string{"INIT", "CREATED", "SCHEDULED", "READY_FOR_REVIEW", "EXECUTION_STARTED", "EXECUTED", "VALIDATED", "FAILEDVALIDATION", "READY_FOR_LIVE", "LIVE", "ENDED"}
+
+// findSurveys returns every survey matching all of the given search parameters
+func findSurveys(ctx context.Context, filters map[string]string) (listOfSurveys []models.Survey, err error) {
+	defer observeQuery(ctx, "find_surveys")(&err)
+
+	var args []interface{}
+	var sb strings.Builder
+
+	sb.WriteString("SELECT id, survey_ref, short_name, long_name, legal_basis, survey_mode FROM " + schemaTable("survey") + " WHERE 1=1")
+
+	for param, value := range filters {
+		column, ok := surveySearchColumns[param]
+		if !ok {
+			return nil, fmt.Errorf("invalid query parameter %s", param)
+		}
+		args = append(args, value)
+		sb.WriteString(" AND " + column + " = $" + strconv.Itoa(len(args)))
+	}
+
+	rows, err := db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("get survey query failed: %w", err)
+	}
+	defer rows.Close()
+
+	listOfSurveys = []models.Survey{}
+
+	for rows.Next() {
+		survey := models.Survey{}
+
+		err = rows.Scan(
+			&survey.ID,
+			&survey.SurveyRef,
+			&survey.ShortName,
+			&survey.LongName,
+			&survey.LegalBasis,
+			&survey.SurveyMode,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning database rows: %w", err)
+		}
+
+		listOfSurveys = append(listOfSurveys, survey)
+	}
+
+	return listOfSurveys, nil
+}
+
+// findSurveysWithInstruments returns every survey matching all of the given search parameters, each with its
+// collection instruments. Like findExerciseDetails, it's a single outer join folded into one result per survey.
+func findSurveysWithInstruments(ctx context.Context, filters map[string]string) (surveys []models.SurveyWithInstruments, err error) {
+	defer observeQuery(ctx, "find_surveys_with_instruments")(&err)
+
+	var args []interface{}
+	var sb strings.Builder
+
+	sb.WriteString("SELECT s.id, s.survey_ref, s.short_name, s.long_name, s.legal_basis, s.survey_mode, " + instrumentColumns +
+		" FROM " + schemaTable("survey") + " s LEFT JOIN " + schemaTable("collection_instrument") + " ci ON ci.survey_ref = s.survey_ref WHERE 1=1")
+
+	for param, value := range filters {
+		column, ok := surveySearchColumns[param]
+		if !ok {
+			return nil, fmt.Errorf("invalid query parameter %s", param)
+		}
+		args = append(args, value)
+		sb.WriteString(" AND s." + column + " = $" + strconv.Itoa(len(args)))
+	}
+	sb.WriteString(" ORDER BY s.survey_ref, ci.instrument_id")
+
+	rows, err := db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("get survey query failed: %w", err)
+	}
+	defer rows.Close()
+
+	surveys = []models.SurveyWithInstruments{}
+	for rows.Next() {
+		var survey models.Survey
+		var instrumentUUID, instrumentType, seftFilename sql.NullString
+		var classifiers []byte
+
+		err = rows.Scan(&survey.ID, &survey.SurveyRef, &survey.ShortName, &survey.LongName, &survey.LegalBasis, &survey.SurveyMode,
+			&instrumentUUID, &instrumentType, &classifiers, &seftFilename)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning database rows: %w", err)
+		}
+
+		if n := len(surveys); n == 0 || surveys[n-1].Survey.ID != survey.ID {
+			surveys = append(surveys, models.SurveyWithInstruments{Survey: survey, CollectionInstruments: []models.CollectionInstrument{}})
+		}
+		if !instrumentUUID.Valid {
+			continue
+		}
+
+		instrument, err := newInstrument(instrumentUUID.String, instrumentType, classifiers, seftFilename)
+		if err != nil {
+			return nil, err
+		}
+		last := &surveys[len(surveys)-1]
+		last.CollectionInstruments = append(last.CollectionInstruments, instrument)
+	}
+	return surveys, rows.Err()
+}
+
+// createSurvey inserts a new survey, generating its ID
+func createSurvey(ctx context.Context, survey *models.Survey) (err error) {
+	defer observeQuery(ctx, "create_survey")(&err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO "+schemaTable("survey")+" VALUES($1, $2, $3, $4, $5, $6)")
+	if err != nil {
+		return fmt.Errorf("SQL statement not prepared: %w", err)
+	}
+	defer stmt.Close()
+
+	// Generate a UUID to uniquely identify the new survey
+	newID, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("error generating random uuid: %w", err)
+	}
+
+	survey.ID = newID.String()
+
+	_, err = stmt.ExecContext(ctx, survey.ID, survey.SurveyRef, survey.ShortName, survey.LongName, survey.LegalBasis, survey.SurveyMode)
+	if err != nil {
+		return fmt.Errorf("SQL statement error: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing database transaction: %w", err)
+	}
+	return nil
+}
+
+// deleteSurvey removes the survey with the given reference, returning errSurveyNotFound if there isn't one
+func deleteSurvey(ctx context.Context, surveyRef string) (err error) {
+	defer observeQuery(ctx, "delete_survey")(&err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = selectSurvey(ctx, tx, surveyRef); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "DELETE FROM "+schemaTable("survey")+" WHERE survey_ref = $1")
+	if err != nil {
+		return fmt.Errorf("SQL statement not prepared: %w", err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, surveyRef)
+	if err != nil {
+		return fmt.Errorf("SQL statement error: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing database transaction: %w", err)
+	}
+	return nil
+}
+
+// updateSurvey applies any non-empty fields of changes to the survey with the given reference and returns the result
+func updateSurvey(ctx context.Context, surveyRef string, changes models.Survey) (_ models.Survey, err error) {
+	defer observeQuery(ctx, "update_survey")(&err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Survey{}, fmt.Errorf("error starting database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := selectSurvey(ctx, tx, surveyRef)
+	if err != nil {
+		return models.Survey{}, err
+	}
+
+	if changes.ShortName == "" {
+		changes.ShortName = existing.ShortName
+	}
+	if changes.LongName == "" {
+		changes.LongName = existing.LongName
+	}
+	if changes.LegalBasis == "" {
+		changes.LegalBasis = existing.LegalBasis
+	}
+	if changes.SurveyMode == "" {
+		changes.SurveyMode = existing.SurveyMode
+	}
+
+	updateQuery := "UPDATE " + schemaTable("survey") + " SET short_name = $1, long_name = $2, legal_basis = $3, survey_mode = $4 WHERE survey_ref = $5"
+
+	stmt, err := tx.PrepareContext(ctx, updateQuery)
+	if err != nil {
+		return models.Survey{}, fmt.Errorf("SQL statement not prepared: %w", err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, changes.ShortName, changes.LongName, changes.LegalBasis, changes.SurveyMode, surveyRef)
+	if err != nil {
+		return models.Survey{}, fmt.Errorf("SQL statement error: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return models.Survey{}, fmt.Errorf("error committing database transaction: %w", err)
+	}
+
+	// redo the select query to show what the survey looks like now
+	updated, err := selectSurvey(ctx, db, surveyRef)
+	if err != nil {
+		return models.Survey{}, fmt.Errorf("second search query failed: %w", err)
+	}
+	return updated, nil
+}
+
+// exerciseFilter selects collection exercises. Empty fields match every exercise.
+type exerciseFilter struct {
+	exerciseUUID string
+	surveyRef    string
+	state        string
+}
+
+// where returns the SQL conditions for the filter, against collection_exercise aliased as ce, and their arguments
+func (f exerciseFilter) where() (string, []interface{}) {
+	var args []interface{}
+	var sb strings.Builder
+	sb.WriteString(" WHERE 1=1")
+	for _, condition := range []struct{ column, value string }{
+		{"ce.exercise_uuid", f.exerciseUUID},
+		{"ce.survey_ref", f.surveyRef},
+		{"ce.state", f.state},
+	} {
+		if condition.value != "" {
+			args = append(args, condition.value)
+			sb.WriteString(" AND " + condition.column + " = $" + strconv.Itoa(len(args)))
+		}
+	}
+	return sb.String(), args
+}
+
+// exerciseColumns are scanned by scanExercise, in order
+const exerciseColumns = "ce.exercise_uuid, ce.survey_ref, COALESCE(ce.state, ''), COALESCE(ce.period_name, ''), " +
+	"ce.mps, ce.go_live, ce.period_start, ce.period_end, ce.employment, ce.return"
+
+// exerciseFields returns where to scan exerciseColumns into
+func exerciseFields(e *models.CollectionExercise) []interface{} {
+	return []interface{}{&e.ExerciseUUID, &e.SurveyReference, &e.State, &e.PeriodName,
+		&e.MPS, &e.GoLive, &e.PeriodStart, &e.PeriodEnd, &e.Employment, &e.Return}
+}
+
+// findExercises returns every collection exercise matching the filter, oldest first
+func findExercises(ctx context.Context, filter exerciseFilter) (exercises []models.CollectionExercise, err error) {
+	defer observeQuery(ctx, "find_exercises")(&err)
+
+	where, args := filter.where()
+	rows, err := db.QueryContext(ctx, "SELECT "+exerciseColumns+" FROM "+schemaTable("collection_exercise")+" ce"+where+" ORDER BY ce.exercise_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("find exercises query failed: %w", err)
+	}
+	defer rows.Close()
+
+	exercises = []models.CollectionExercise{}
+	for rows.Next() {
+		var exercise models.CollectionExercise
+		if err = rows.Scan(exerciseFields(&exercise)...); err != nil {
+			return nil, fmt.Errorf("error scanning database rows: %w", err)
+		}
+		exercises = append(exercises, exercise)
+	}
+	return exercises, rows.Err()
+}
+
+// findExerciseDetails returns every collection exercise matching the filter with its survey and linked instruments,
+// oldest first. It's a single query, with a row for each exercise and instrument pair, which is folded into one
+// detail per exercise, rather than a query per exercise.
+func findExerciseDetails(ctx context.Context, filter exerciseFilter) (details []models.CollectionExerciseDetail, err error) {
+	defer observeQuery(ctx, "find_exercise_details")(&err)
+
+	where, args := filter.where()
+	query := "SELECT " + exerciseColumns + ", s.id, s.survey_ref, COALESCE(s.short_name, ''), COALESCE(s.long_name, ''), " +
+		"COALESCE(s.legal_basis, ''), COALESCE(s.survey_mode, ''), " + instrumentColumns +
+		" FROM " + schemaTable("collection_exercise") + " ce" +
+		" JOIN " + schemaTable("survey") + " s ON s.survey_ref = ce.survey_ref" +
+		" LEFT JOIN " + schemaTable("associated_instruments") + " ai ON ai.exercise_id = ce.exercise_id" +
+		" LEFT JOIN " + schemaTable("collection_instrument") + " ci ON ci.instrument_id = ai.instrument_id" +
+		where + " ORDER BY ce.exercise_id, ci.instrument_id"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("find exercise details query failed: %w", err)
+	}
+	defer rows.Close()
+
+	details = []models.CollectionExerciseDetail{}
+	for rows.Next() {
+		var detail models.CollectionExerciseDetail
+		var instrumentUUID, instrumentType, seftFilename sql.NullString
+		var classifiers []byte
+
+		fields := append(exerciseFields(&detail.CollectionExercise),
+			&detail.Survey.ID, &detail.Survey.SurveyRef, &detail.Survey.ShortName, &detail.Survey.LongName,
+			&detail.Survey.LegalBasis, &detail.Survey.SurveyMode, &instrumentUUID, &instrumentType, &classifiers, &seftFilename)
+		if err = rows.Scan(fields...); err != nil {
+			return nil, fmt.Errorf("error scanning database rows: %w", err)
+		}
+
+		// Rows for the same exercise are adjacent, as they're ordered by it
+		if n := len(details); n == 0 || details[n-1].CollectionExercise.ExerciseUUID != detail.CollectionExercise.ExerciseUUID {
+			detail.CollectionInstruments = []models.CollectionInstrument{}
+			details = append(details, detail)
+		}
+		if !instrumentUUID.Valid {
+			continue
+		}
+
+		instrument, err := newInstrument(instrumentUUID.String, instrumentType, classifiers, seftFilename)
+		if err != nil {
+			return nil, err
+		}
+		last := &details[len(details)-1]
+		last.CollectionInstruments = append(last.CollectionInstruments, instrument)
+	}
+	return details, rows.Err()
+}
+
+// instrumentColumns are the collection_instrument columns, aliased as ci, scanned for newInstrument
+const instrumentColumns = "ci.instrument_uuid, ci.type, ci.classifiers, ci.seft_filename"
+
+// newInstrument builds an instrument from its columns, which may be NULL as they're usually outer joined
+func newInstrument(instrumentUUID string, instrumentType sql.NullString, classifiers []byte, seftFilename sql.NullString) (models.CollectionInstrument, error) {
+	instrument := models.CollectionInstrument{
+		InstrumentUUID: instrumentUUID,
+		InstrumentType: instrumentType.String,
+		SEFTFilename:   seftFilename.String,
+	}
+	if len(classifiers) > 0 {
+		if err := json.Unmarshal(classifiers, &instrument.Classifiers); err != nil {
+			return instrument, fmt.Errorf("invalid classifiers for instrument %s: %w", instrumentUUID, err)
+		}
+	}
+	return instrument, nil
+}
+
+// instrumentFilter selects collection instruments. Empty fields match every instrument, and the instrument's
+// classifiers must include every one of classifiers.
+type instrumentFilter struct {
+	surveyRef      string
+	instrumentType string
+	classifiers    map[string]string
+}
+
+// findInstruments returns every collection instrument matching the filter, oldest first. Classifiers are matched with
+// jsonb containment, which the collection_instrument_classifiers index serves.
+func findInstruments(ctx context.Context, filter instrumentFilter) (instruments []models.CollectionInstrument, err error) {
+	defer observeQuery(ctx, "find_instruments")(&err)
+
+	var args []interface{}
+	var sb strings.Builder
+	sb.WriteString("SELECT " + instrumentColumns + " FROM " + schemaTable("collection_instrument") + " ci WHERE 1=1")
+	for _, condition := range []struct{ column, value string }{
+		{"ci.survey_ref", filter.surveyRef},
+		{"ci.type", filter.instrumentType},
+	} {
+		if condition.value != "" {
+			args = append(args, condition.value)
+			sb.WriteString(" AND " + condition.column + " = $" + strconv.Itoa(len(args)))
+		}
+	}
+	if len(filter.classifiers) > 0 {
+		classifiers, err := json.Marshal(filter.classifiers)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't encode classifiers: %w", err)
+		}
+		args = append(args, string(classifiers))
+		sb.WriteString(" AND ci.classifiers @> $" + strconv.Itoa(len(args)) + "::jsonb")
+	}
+	sb.WriteString(" ORDER BY ci.instrument_id")
+
+	rows, err := db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("find instruments query failed: %w", err)
+	}
+	defer rows.Close()
+
+	instruments = []models.CollectionInstrument{}
+	for rows.Next() {
+		var instrumentUUID string
+		var instrumentType, seftFilename sql.NullString
+		var classifiers []byte
+		if err = rows.Scan(&instrumentUUID, &instrumentType, &classifiers, &seftFilename); err != nil {
+			return nil, fmt.Errorf("error scanning database rows: %w", err)
+		}
+
+		instrument, err := newInstrument(instrumentUUID, instrumentType, classifiers, seftFilename)
+		if err != nil {
+			return nil, err
+		}
+		instruments = append(instruments, instrument)
+	}
+	return instruments, rows.Err()
+}
+
+// instrumentFile describes the file stored for a SEFT collection instrument
+type instrumentFile struct {
+	filename string
+	size     int64
+	sha256   []byte
+}
+
+// createInstrument adds a collection instrument to the survey, with the details of its file if it has one. It returns
+// errSurveyNotFound if there's no such survey.
+func createInstrument(ctx context.Context, surveyRef string, instrument models.CollectionInstrument, file *instrumentFile) (err error) {
+	defer observeQuery(ctx, "create_instrument")(&err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = selectSurvey(ctx, tx, surveyRef); err != nil {
+		return err
+	}
+
+	var classifiers []byte
+	if instrument.Classifiers != nil {
+		if classifiers, err = json.Marshal(instrument.Classifiers); err != nil {
+			return fmt.Errorf("couldn't encode classifiers: %w", err)
+		}
+	}
+	var filename, size, sha256 interface{}
+	if file != nil {
+		filename, size, sha256 = file.filename, file.size, file.sha256
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+schemaTable("collection_instrument")+
+		" (survey_ref, instrument_uuid, type, classifiers, seft_filename, file_size, file_sha256)"+
+		" VALUES($1, $2, $3, $4, $5, $6, $7)",
+		surveyRef, instrument.InstrumentUUID, instrument.InstrumentType, classifiers, filename, size, sha256)
+	if err != nil {
+		return fmt.Errorf("SQL statement error: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing database transaction: %w", err)
+	}
+	return nil
+}
+
+// findInstrumentFile returns the file stored for the collection instrument with the given UUID. It returns
+// errInstrumentNotFound if there's no such instrument or it has no file.
+func findInstrumentFile(ctx context.Context, instrumentUUID string) (file instrumentFile, err error) {
+	defer observeQuery(ctx, "find_instrument_file")(&err)
+
+	err = db.QueryRowContext(ctx, "SELECT seft_filename, file_size, file_sha256 FROM "+schemaTable("collection_instrument")+
+		" WHERE instrument_uuid = $1 AND file_sha256 IS NOT NULL", instrumentUUID).Scan(&file.filename, &file.size, &file.sha256)
+	if err == sql.ErrNoRows {
+		return file, errInstrumentNotFound
+	}
+	if err != nil {
+		return file, fmt.Errorf("find instrument file query failed: %w", err)
+	}
+	return file, nil
+}
+
+// createExercise inserts a new collection exercise for an existing survey, generating its UUID. It returns
+// errSurveyNotFound if the survey doesn't exist.
+func createExercise(ctx context.Context, exercise *models.CollectionExercise) (err error) {
+	defer observeQuery(ctx, "create_exercise")(&err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = selectSurvey(ctx, tx, exercise.SurveyReference); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO "+schemaTable("collection_exercise")+
+		" (exercise_uuid, survey_ref, state, period_name, mps, go_live, period_start, period_end, employment, return)"+
+		" VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)")
+	if err != nil {
+		return fmt.Errorf("SQL statement not prepared: %w", err)
+	}
+	defer stmt.Close()
+
+	newID, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("error generating random uuid: %w", err)
+	}
+	exercise.ExerciseUUID = newID.String()
+
+	_, err = stmt.ExecContext(ctx, exercise.ExerciseUUID, exercise.SurveyReference, exercise.State, exercise.PeriodName,
+		exercise.MPS, exercise.GoLive, exercise.PeriodStart, exercise.PeriodEnd, exercise.Employment, exercise.Return)
+	if isUniqueViolation(err, exerciseSurveyPeriodKey) {
+		// The transaction is aborted, so the existing exercise is looked up outside it
+		tx.Rollback()
+		return existingExercise(ctx, exercise.SurveyReference, exercise.PeriodName)
+	}
+	if err != nil {
+		return fmt.Errorf("SQL statement error: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing database transaction: %w", err)
+	}
+	return nil
+}
+
+// generateExercises creates the given collection exercises for the survey in one transaction, skipping any whose
+// period the survey already has. It returns errSurveyNotFound if there's no such survey.
+func generateExercises(ctx context.Context, surveyRef string, exercises []models.CollectionExercise) (generated models.GeneratedExercises, err error) {
+	defer observeQuery(ctx, "generate_exercises")(&err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return generated, fmt.Errorf("error starting database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = selectSurvey(ctx, tx, surveyRef); err != nil {
+		return generated, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO "+schemaTable("collection_exercise")+
+		" (exercise_uuid, survey_ref, state, period_name, mps, go_live, period_start, period_end, employment, return)"+
+		" VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"+
+		" ON CONFLICT ON CONSTRAINT "+exerciseSurveyPeriodKey+" DO NOTHING")
+	if err != nil {
+		return generated, fmt.Errorf("SQL statement not prepared: %w", err)
+	}
+	defer stmt.Close()
+
+	generated = models.GeneratedExercises{Created: []models.CollectionExercise{}, Skipped: []string{}}
+	for _, exercise := range exercises {
+		newID, err := uuid.NewV4()
+		if err != nil {
+			return generated, fmt.Errorf("error generating random uuid: %w", err)
+		}
+		exercise.ExerciseUUID = newID.String()
+
+		result, err := stmt.ExecContext(ctx, exercise.ExerciseUUID, surveyRef, exercise.State, exercise.PeriodName,
+			exercise.MPS, exercise.GoLive, exercise.PeriodStart, exercise.PeriodEnd, exercise.Employment, exercise.Return)
+		if err != nil {
+			return generated, fmt.Errorf("SQL statement error: %w", err)
+		}
+		if inserted, err := result.RowsAffected(); err != nil {
+			return generated, fmt.Errorf("error getting rows affected: %w", err)
+		} else if inserted == 0 {
+			generated.Skipped = append(generated.Skipped, exercise.PeriodName)
+			continue
+		}
+		generated.Created = append(generated.Created, exercise)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return generated, fmt.Errorf("error committing database transaction: %w", err)
+	}
+	return generated, nil
+}
+
+// existingExercise returns an exerciseExistsError for the survey's exercise for the period
+func existingExercise(ctx context.Context, surveyRef string, periodName string) error {
+	var existing string
+	err := db.QueryRowContext(ctx, "SELECT exercise_uuid FROM "+schemaTable("collection_exercise")+" WHERE survey_ref = $1 AND period_name = $2",
+		surveyRef, periodName).Scan(&existing)
+	if err != nil {
+		return fmt.Errorf("couldn't find the existing exercise for the period: %w", err)
+	}
+	return &exerciseExistsError{exerciseUUID: existing}
+}
+
+// cloneExercise copies the collection exercise with the given UUID to a new period, in the CREATED state, with its
+// dates and scheduled emails moved on by the shift and the same instruments linked. It returns errExerciseNotFound if
+// there's no such exercise, an exerciseExistsError if the survey already has the period and an exerciseDatesError if
+// the moved dates break the survey's rules.
+func cloneExercise(ctx context.Context, exerciseUUID string, clone models.CloneExercise) (exercise models.CollectionExercise, err error) {
+	defer observeQuery(ctx, "clone_exercise")(&err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return exercise, fmt.Errorf("error starting database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var sourceID int
+	var surveyRef string
+	err = tx.QueryRowContext(ctx, "SELECT exercise_id, survey_ref FROM "+schemaTable("collection_exercise")+" WHERE exercise_uuid = $1",
+		exerciseUUID).Scan(&sourceID, &surveyRef)
+	if err == sql.ErrNoRows {
+		return exercise, errExerciseNotFound
+	}
+	if err != nil {
+		return exercise, fmt.Errorf("check query failed: %w", err)
+	}
+
+	newID, err := uuid.NewV4()
+	if err != nil {
+		return exercise, fmt.Errorf("error generating random uuid: %w", err)
+	}
+
+	// $3 and $4 shift by months then days, so a monthly exercise on the 31st lands on the last day of shorter months
+	shift := func(column string) string {
+		return column + " + make_interval(months => $3, days => $4)"
+	}
+	var cloneID int
+	row := tx.QueryRowContext(ctx, "INSERT INTO "+schemaTable("collection_exercise")+" AS ce"+
+		" (exercise_uuid, survey_ref, state, period_name, mps, go_live, period_start, period_end, employment, return)"+
+		" SELECT $2, survey_ref, 'CREATED', $5, "+shift("mps")+", "+shift("go_live")+", "+shift("period_start")+", "+
+		shift("period_end")+", "+shift("employment")+", "+shift("return")+
+		" FROM "+schemaTable("collection_exercise")+" WHERE exercise_id = $1"+
+		" RETURNING ce.exercise_id, "+exerciseColumns,
+		sourceID, newID.String(), clone.ShiftMonths, clone.ShiftDays, clone.PeriodName)
+	err = row.Scan(append([]interface{}{&cloneID}, exerciseFields(&exercise)...)...)
+	if isUniqueViolation(err, exerciseSurveyPeriodKey) {
+		tx.Rollback()
+		return exercise, existingExercise(ctx, surveyRef, clone.PeriodName)
+	}
+	if err != nil {
+		return exercise, fmt.Errorf("couldn't copy the exercise: %w", err)
+	}
+
+	if problems := checkExerciseDates(exercise); len(problems) > 0 {
+		return exercise, &exerciseDatesError{problems: problems}
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+schemaTable("associated_instruments")+" (exercise_id, instrument_id)"+
+		" SELECT $2, instrument_id FROM "+schemaTable("associated_instruments")+" WHERE exercise_id = $1", sourceID, cloneID)
+	if err != nil {
+		return exercise, fmt.Errorf("couldn't copy the exercise's instruments: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+schemaTable("email")+" (exercise_id, type, time_scheduled)"+
+		" SELECT $2, type, time_scheduled + make_interval(months => $3, days => $4) FROM "+schemaTable("email")+
+		" WHERE exercise_id = $1 ORDER BY email_id", sourceID, cloneID, clone.ShiftMonths, clone.ShiftDays)
+	if err != nil {
+		return exercise, fmt.Errorf("couldn't copy the exercise's emails: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return exercise, fmt.Errorf("error committing database transaction: %w", err)
+	}
+	return exercise, nil
+}
+
+// findEmailEvents returns the scheduled emails of the collection exercise with the given UUID as timeline events,
+// in time order. Emails without a time aren't included.
+func findEmailEvents(ctx context.Context, exerciseUUID string) (events []models.TimelineEvent, err error) {
+	defer observeQuery(ctx, "find_email_events")(&err)
+
+	rows, err := db.QueryContext(ctx, "SELECT COALESCE(e.type, ''), e.time_scheduled FROM "+schemaTable("email")+" e"+
+		" JOIN "+schemaTable("collection_exercise")+" ce ON ce.exercise_id = e.exercise_id"+
+		" WHERE ce.exercise_uuid = $1 AND e.time_scheduled IS NOT NULL ORDER BY e.time_scheduled, e.email_id", exerciseUUID)
+	if err != nil {
+		return nil, fmt.Errorf("find emails query failed: %w", err)
+	}
+	defer rows.Close()
+
+	events = []models.TimelineEvent{}
+	for rows.Next() {
+		event := models.TimelineEvent{Type: models.EventTypeEmail}
+		if err = rows.Scan(&event.Name, &event.Time); err != nil {
+			return nil, fmt.Errorf("error scanning database rows: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// countExercisesByState returns how many collection exercises are in each state
+func countExercisesByState(ctx context.Context) (counts map[string]int, err error) {
+	defer observeQuery(ctx, "count_exercises_by_state")(&err)
+
+	rows, err := db.QueryContext(ctx, "SELECT COALESCE(state, ''), count(*) FROM "+schemaTable("collection_exercise")+" GROUP BY state")
+	if err != nil {
+		return nil, fmt.Errorf("count exercises query failed: %w", err)
+	}
+	defer rows.Close()
+
+	counts = map[string]int{}
+	for rows.Next() {
+		var state string
+		var count int
+		if err = rows.Scan(&state, &count); err != nil {
+			return nil, fmt.Errorf("error scanning database rows: %w", err)
+		}
+		counts[state] = count
+	}
+	return counts, rows.Err()
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx, so lookups can run inside or outside a transaction
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// selectSurvey fetches a single survey by reference, returning errSurveyNotFound if there isn't one
+func selectSurvey(ctx context.Context, q queryRower, surveyRef string) (survey models.Survey, err error) {
+	defer observeQuery(ctx, "select_survey")(&err)
+
+	result := q.QueryRowContext(ctx, "SELECT * FROM "+schemaTable("survey")+" WHERE survey_ref = $1", surveyRef)
+	err = result.Scan(&survey.ID, &survey.SurveyRef, &survey.ShortName, &survey.LongName, &survey.LegalBasis, &survey.SurveyMode)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return survey, errSurveyNotFound
+		}
+		return survey, fmt.Errorf("check query failed: %w", err)
+	}
+	return survey, nil
+}
+
+// transitionExercise moves the collection exercise with the given UUID into a new state, if allowedTransitions lets it
+// move there from the state it's in, recording an exercise_event in the same transaction
+func transitionExercise(ctx context.Context, exerciseUUID string, state string) (err error) {
+	if !isExerciseState(state) {
+		return fmt.Errorf("%w: %s", errInvalidState, state)
+	}
+	if _, err := uuid.FromString(exerciseUUID); err != nil {
+		return fmt.Errorf("invalid collection exercise UUID %s: %w", exerciseUUID, err)
+	}
+
+	defer observeQuery(ctx, "transition_exercise")(&err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var from string
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(state, '') FROM "+schemaTable("collection_exercise")+" WHERE exercise_uuid = $1 FOR UPDATE",
+		exerciseUUID).Scan(&from)
+	if err == sql.ErrNoRows {
+		return errExerciseNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("check query failed: %w", err)
+	}
+	if !canTransition(from, state) {
+		return fmt.Errorf("%w: %s to %s", errInvalidTransition, from, state)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE "+schemaTable("collection_exercise")+" SET state = $1 WHERE exercise_uuid = $2", state, exerciseUUID)
+	if err != nil {
+		return fmt.Errorf("SQL statement error: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+schemaTable("exercise_event")+" (exercise_uuid, event_type, from_state, to_state, traceparent)"+
+		" VALUES ($1, '"+eventStateChanged+"', $2, $3, NULLIF($4, ''))", exerciseUUID, from, state, traceparent(ctx))
+	if err != nil {
+		return fmt.Errorf("couldn't record the transition: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing database transaction: %w", err)
+	}
+	return nil
+}
+
+// schemaTable qualifies a table name with the configured schema. The schema name is checked by Config.validate at
+// startup, as it can't be passed as a query parameter.
+func schemaTable(table string) string {
+	return config.DB.Schema + "." + table
+}
+
+func isExerciseState(state string) bool {
+	for _, s := range exerciseStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
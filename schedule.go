@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/models"
+)
+
+// maxGeneratedPeriods limits how many collection exercises one request can generate
+const maxGeneratedPeriods = 120
+
+// scheduleFrequencies are the cadences a survey can run on, by the months in each period
+var scheduleFrequencies = map[string]int{
+	"monthly":   1,
+	"quarterly": 3,
+	"annually":  12,
+}
+
+// isoOffset matches an ISO 8601 duration of years, months, days and a time of day, optionally negative, e.g. P1M14D
+// or -P10DT9H
+var isoOffset = regexp.MustCompile(`^(-)?P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?)?$`)
+
+// periodOffset moves a time on by months, then days, then a time of day. The parts of a negative offset are negated.
+type periodOffset struct {
+	months int
+	days   int
+	clock  time.Duration
+}
+
+// parseOffset reads an ISO 8601 duration, e.g. P1M14DT9H
+func parseOffset(offset string) (periodOffset, error) {
+	parts := isoOffset.FindStringSubmatch(offset)
+	if parts == nil || offset == "P" || offset == "-P" || strings.HasSuffix(offset, "T") {
+		return periodOffset{}, fmt.Errorf("offset %q must be an ISO 8601 duration such as P1M14D or -P10DT9H", offset)
+	}
+	n := make([]int, 5)
+	for i, part := range parts[2:] {
+		if part != "" {
+			n[i], _ = strconv.Atoi(part)
+		}
+	}
+	o := periodOffset{months: n[0]*12 + n[1], days: n[2], clock: time.Duration(n[3])*time.Hour + time.Duration(n[4])*time.Minute}
+	if parts[1] == "-" {
+		o = periodOffset{months: -o.months, days: -o.days, clock: -o.clock}
+	}
+	return o, nil
+}
+
+func (o periodOffset) from(t time.Time) *time.Time {
+	moved := t.AddDate(0, o.months, o.days).Add(o.clock)
+	return &moved
+}
+
+// exerciseSchedule is a parsed ExerciseSchedule
+type exerciseSchedule struct {
+	months  int
+	pattern string
+	offsets map[string]periodOffset
+}
+
+// parseSchedule checks a schedule, returning the first problem with it
+func parseSchedule(s ExerciseSchedule) (exerciseSchedule, error) {
+	months, ok := scheduleFrequencies[s.Frequency]
+	if !ok {
+		return exerciseSchedule{}, fmt.Errorf("frequency %q must be one of monthly, quarterly or annually", s.Frequency)
+	}
+
+	// The name has to tell the periods apart, as a survey has one exercise per period
+	tokens := map[string]bool{}
+	formatPeriodName(s.PeriodName, func(token string) string {
+		tokens[token] = true
+		return ""
+	})
+	hasYear := tokens["YYYY"] || tokens["YY"]
+	hasPeriod := months == 12 || tokens["MM"] || (months == 3 && tokens["Q"])
+	if !hasYear || !hasPeriod {
+		return exerciseSchedule{}, fmt.Errorf("period_name %q must name the year and the %s period, e.g. with YYYY, MM or Q", s.PeriodName, s.Frequency)
+	}
+
+	parsed := exerciseSchedule{months: months, pattern: s.PeriodName, offsets: map[string]periodOffset{}}
+	for date, offset := range map[string]string{"mps": s.MPS, "go_live": s.GoLive, "return": s.Return} {
+		if offset == "" {
+			continue
+		}
+		o, err := parseOffset(offset)
+		if err != nil {
+			return exerciseSchedule{}, fmt.Errorf("%s %w", date, err)
+		}
+		parsed.offsets[date] = o
+	}
+	return parsed, nil
+}
+
+// scheduleFor returns the survey's schedule from exercise_schedules, if it has one. The schedules are checked by
+// Config.validate at startup, so they parse.
+func scheduleFor(surveyRef string) (exerciseSchedule, bool) {
+	s, ok := config.ExerciseSchedules[surveyRef]
+	if !ok {
+		return exerciseSchedule{}, false
+	}
+	parsed, err := parseSchedule(s)
+	return parsed, err == nil
+}
+
+// periodNameTokens are replaced in a period_name pattern, longest first
+var periodNameTokens = []string{"YYYY", "YY", "MM", "Q"}
+
+// formatPeriodName writes a period_name pattern, calling token for each token in it. Text in single quotes is copied
+// as it is, so YYYY'Q'Q names the first quarter of 2021 2021Q1.
+func formatPeriodName(pattern string, token func(string) string) string {
+	var name strings.Builder
+	for i := 0; i < len(pattern); {
+		if pattern[i] == '\'' {
+			end := strings.IndexByte(pattern[i+1:], '\'')
+			if end < 0 {
+				end = len(pattern) - i - 1
+			}
+			name.WriteString(pattern[i+1 : i+1+end])
+			i += end + 2
+			continue
+		}
+		matched := false
+		for _, t := range periodNameTokens {
+			if strings.HasPrefix(pattern[i:], t) {
+				name.WriteString(token(t))
+				i += len(t)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			name.WriteByte(pattern[i])
+			i++
+		}
+	}
+	return name.String()
+}
+
+func (s exerciseSchedule) periodName(start time.Time) string {
+	year := strconv.Itoa(start.Year())
+	return formatPeriodName(s.pattern, func(token string) string {
+		switch token {
+		case "YYYY":
+			return year
+		case "YY":
+			return year[len(year)-2:]
+		case "MM":
+			return fmt.Sprintf("%02d", int(start.Month()))
+		default:
+			return strconv.Itoa((int(start.Month())-1)/3 + 1)
+		}
+	})
+}
+
+// periodStarts returns the start of every period that starts between from and to, inclusive. It stops once it has
+// more than maxGeneratedPeriods, which is already too many to generate, so a far-off to can't keep it going.
+func (s exerciseSchedule) periodStarts(from time.Time, to time.Time) []time.Time {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	month := (int(from.Month()) - 1) / s.months * s.months
+	start := time.Date(from.Year(), time.Month(month+1), 1, 0, 0, 0, 0, time.UTC)
+	if start.Before(from) {
+		start = start.AddDate(0, s.months, 0)
+	}
+
+	var starts []time.Time
+	for ; !start.After(to) && len(starts) <= maxGeneratedPeriods; start = start.AddDate(0, s.months, 0) {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// exercises returns a CREATED collection exercise for every period of the survey that starts between from and to.
+// Each period ends the day before the next starts.
+func (s exerciseSchedule) exercises(surveyRef string, from time.Time, to time.Time) []models.CollectionExercise {
+	var exercises []models.CollectionExercise
+	for _, start := range s.periodStarts(from, to) {
+		start := start
+		exercise := models.CollectionExercise{
+			SurveyReference: surveyRef,
+			State:           "CREATED",
+			PeriodName:      s.periodName(start),
+			PeriodStart:     &start,
+			PeriodEnd:       periodOffset{months: s.months, days: -1}.from(start),
+		}
+		if o, ok := s.offsets["mps"]; ok {
+			exercise.MPS = o.from(start)
+		}
+		if o, ok := s.offsets["go_live"]; ok {
+			exercise.GoLive = o.from(start)
+		}
+		if o, ok := s.offsets["return"]; ok {
+			exercise.Return = o.from(start)
+		}
+		exercises = append(exercises, exercise)
+	}
+	return exercises
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOffset(t *testing.T) {
+	o, err := parseOffset("P1Y2M3DT9H30M")
+	assert.NoError(t, err)
+	assert.Equal(t, periodOffset{months: 14, days: 3, clock: 9*time.Hour + 30*time.Minute}, o)
+
+	o, err = parseOffset("-P10D")
+	assert.NoError(t, err)
+	assert.Equal(t, periodOffset{days: -10}, o)
+
+	for _, invalid := range []string{"", "P", "-P", "PT", "10d", "P1W", "P-1D"} {
+		_, err = parseOffset(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseScheduleChecksPeriodNamesAreDistinct(t *testing.T) {
+	_, err := parseSchedule(ExerciseSchedule{Frequency: "monthly", PeriodName: "YYYYMM"})
+	assert.NoError(t, err)
+	_, err = parseSchedule(ExerciseSchedule{Frequency: "quarterly", PeriodName: "YYYY'Q'Q"})
+	assert.NoError(t, err)
+	_, err = parseSchedule(ExerciseSchedule{Frequency: "annually", PeriodName: "YYYY"})
+	assert.NoError(t, err)
+
+	_, err = parseSchedule(ExerciseSchedule{Frequency: "monthly", PeriodName: "YYYY"})
+	assert.EqualError(t, err, `period_name "YYYY" must name the year and the monthly period, e.g. with YYYY, MM or Q`)
+	_, err = parseSchedule(ExerciseSchedule{Frequency: "quarterly", PeriodName: "YYYY'Q'"})
+	assert.Error(t, err)
+	_, err = parseSchedule(ExerciseSchedule{Frequency: "weekly", PeriodName: "YYYYMM"})
+	assert.EqualError(t, err, `frequency "weekly" must be one of monthly, quarterly or annually`)
+	_, err = parseSchedule(ExerciseSchedule{Frequency: "monthly", PeriodName: "YYYYMM", GoLive: "9h"})
+	assert.EqualError(t, err, `go_live offset "9h" must be an ISO 8601 duration such as P1M14D or -P10DT9H`)
+}
+
+func TestScheduleExercisesForMonthlySurvey(t *testing.T) {
+	schedule, err := parseSchedule(ExerciseSchedule{Frequency: "monthly", PeriodName: "YYYYMM", MPS: "-P10D", GoLive: "PT9H", Return: "P1M14D"})
+	assert.NoError(t, err)
+
+	exercises := schedule.exercises("141", time.Date(2020, 12, 15, 0, 0, 0, 0, time.UTC), time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC))
+
+	assert.Len(t, exercises, 2)
+	assert.Equal(t, "202101", exercises[0].PeriodName)
+	assert.Equal(t, "CREATED", exercises[0].State)
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), *exercises[0].PeriodStart)
+	assert.Equal(t, time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC), *exercises[0].PeriodEnd)
+	assert.Equal(t, time.Date(2020, 12, 22, 0, 0, 0, 0, time.UTC), *exercises[0].MPS)
+	assert.Equal(t, time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC), *exercises[0].GoLive)
+	assert.Equal(t, time.Date(2021, 2, 15, 0, 0, 0, 0, time.UTC), *exercises[0].Return)
+	assert.Nil(t, exercises[0].Employment)
+	assert.Equal(t, "202102", exercises[1].PeriodName)
+	assert.Equal(t, time.Date(2021, 2, 28, 0, 0, 0, 0, time.UTC), *exercises[1].PeriodEnd)
+}
+
+func TestScheduleExercisesForQuarterlyAndAnnualSurveys(t *testing.T) {
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	quarterly, _ := parseSchedule(ExerciseSchedule{Frequency: "quarterly", PeriodName: "YY'Q'Q"})
+	var names []string
+	for _, exercise := range quarterly.exercises("141", from, to) {
+		names = append(names, exercise.PeriodName)
+	}
+	assert.Equal(t, []string{"21Q1", "21Q2", "21Q3", "21Q4"}, names)
+
+	annual, _ := parseSchedule(ExerciseSchedule{Frequency: "annually", PeriodName: "YYYY"})
+	exercises := annual.exercises("141", from, to)
+	assert.Len(t, exercises, 1)
+	assert.Equal(t, "2021", exercises[0].PeriodName)
+	assert.Equal(t, time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), *exercises[0].PeriodEnd)
+}
+
+func TestScheduleStopsOnceThereAreTooManyPeriods(t *testing.T) {
+	monthly, _ := parseSchedule(ExerciseSchedule{Frequency: "monthly", PeriodName: "YYYYMM"})
+
+	exercises := monthly.exercises("141", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC))
+
+	assert.Len(t, exercises, maxGeneratedPeriods+1)
+}
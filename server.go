@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+)
+
+// backgroundWorker is a long running task, such as a scheduler, which must return once ctx is cancelled
+type backgroundWorker struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+// backgroundWorkers are started alongside the HTTP server and stopped when it shuts down
+var backgroundWorkers []backgroundWorker
+
+// registerWorker adds a worker to be run for the lifetime of the HTTP server
+func registerWorker(name string, run func(ctx context.Context)) {
+	backgroundWorkers = append(backgroundWorkers, backgroundWorker{name: name, run: run})
+}
+
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         ":" + strconv.Itoa(config.HTTP.Port),
+		Handler:      handler,
+		ReadTimeout:  config.HTTP.ReadTimeout,
+		WriteTimeout: config.HTTP.WriteTimeout,
+		IdleTimeout:  config.HTTP.IdleTimeout,
+		ConnContext:  withConn,
+	}
+}
+
+type connKey struct{}
+
+// withConn keeps each request's connection in its context, for extendConnDeadlines
+func withConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+// extendConnDeadlines lets a request read its body and write its response until timeout from now, rather than until
+// http_read_timeout and http_write_timeout after it arrived. The server resets the deadlines for the next request.
+func extendConnDeadlines(r *http.Request, timeout time.Duration) {
+	conn, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		logger.ForContext(r.Context()).Warnw("Couldn't extend the connection's read deadline", "error", err.Error())
+	}
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		logger.ForContext(r.Context()).Warnw("Couldn't extend the connection's write deadline", "error", err.Error())
+	}
+}
+
+// newAdminServer is newServer for the admin endpoints, on admin_port
+func newAdminServer(handler http.Handler) *http.Server {
+	server := newServer(handler)
+	server.Addr = ":" + strconv.Itoa(config.HTTP.AdminPort)
+	return server
+}
+
+// adminServerWorker serves the admin endpoints on listener until the worker is stopped, giving in-flight requests up to
+// shutdown_timeout to finish
+func adminServerWorker(server *http.Server, listener net.Listener) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		serverErr := make(chan error, 1)
+		go func() {
+			serverErr <- server.Serve(listener)
+		}()
+		logger.Logger.Info("Admin endpoints listening on " + listener.Addr().String())
+
+		select {
+		case err := <-serverErr:
+			logger.Logger.Error("Admin server stopped ", err)
+			return
+		case <-ctx.Done():
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.HTTP.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Logger.Warn("Couldn't shut the admin server down cleanly ", err)
+		}
+	}
+}
+
+// runServer serves HTTP until SIGTERM or SIGINT, then shuts down gracefully
+func runServer(handler http.Handler) error {
+	server := newServer(handler)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		logger.Logger.Info("Received " + sig.String() + ", shutting down")
+		cancel()
+	}()
+
+	err = serveUntil(ctx, server, listener)
+	if closeErr := db.Close(); closeErr != nil {
+		logger.Logger.Warn("Couldn't close the database connection ", closeErr)
+	}
+	logger.Logger.Info("ras-rm-survey stopped")
+	return err
+}
+
+// serveUntil runs the server and background workers until ctx is cancelled. It then stops accepting connections,
+// waits up to shutdown_timeout for in-flight requests to finish and stops the workers.
+func serveUntil(ctx context.Context, server *http.Server, listener net.Listener) error {
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	for _, worker := range backgroundWorkers {
+		workers.Add(1)
+		go func(worker backgroundWorker) {
+			defer workers.Done()
+			logger.Logger.Info("Starting " + worker.name)
+			worker.run(workerCtx)
+			logger.Logger.Info("Stopped " + worker.name)
+		}(worker)
+	}
+	defer func() {
+		stopWorkers()
+		workers.Wait()
+	}()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Serve(listener)
+	}()
+	logger.Logger.Info("Listening on " + listener.Addr().String())
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.HTTP.ShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeUntilDrainsRequestsAndStopsWorkers(t *testing.T) {
+	config = defaultConfig()
+
+	workerStopped := make(chan struct{})
+	backgroundWorkers = nil
+	registerWorker("test worker", func(ctx context.Context) {
+		<-ctx.Done()
+		close(workerStopped)
+	})
+	defer func() { backgroundWorkers = nil }()
+
+	requestStarted := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requestStarted)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening on a local port, ", err.Error())
+	}
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serveUntil(ctx, newServer(handler), listener)
+	}()
+
+	responses := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responses <- 0
+			return
+		}
+		resp.Body.Close()
+		responses <- resp.StatusCode
+	}()
+
+	<-requestStarted
+	shutdown()
+
+	assert.Equal(t, http.StatusOK, <-responses, "in-flight request should complete during shutdown")
+	assert.NoError(t, <-served)
+
+	select {
+	case <-workerStopped:
+	default:
+		t.Error("background worker wasn't stopped")
+	}
+}
+
+func TestExtendConnDeadlinesOutlastsTheReadTimeout(t *testing.T) {
+	config = defaultConfig()
+	config.HTTP.ReadTimeout = 100 * time.Millisecond
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		extendConnDeadlines(r, 5*time.Second)
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusRequestTimeout)
+			return
+		}
+		w.Write(body)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening on a local port, ", err.Error())
+	}
+	server := newServer(handler)
+	go server.Serve(listener)
+	defer server.Close()
+
+	// The body takes longer to arrive than the read timeout allows
+	body, upload := io.Pipe()
+	go func() {
+		upload.Write([]byte("a slow "))
+		time.Sleep(300 * time.Millisecond)
+		upload.Write([]byte("upload"))
+		upload.Close()
+	}()
+	resp, err := http.Post("http://"+listener.Addr().String(), "application/octet-stream", body)
+	if err != nil {
+		t.Fatal("Error uploading to the server, ", err.Error())
+	}
+	defer resp.Body.Close()
+	received, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "a slow upload", string(received))
+}
+
+func TestNewServerUsesConfiguredPortAndTimeouts(t *testing.T) {
+	config = defaultConfig()
+
+	server := newServer(http.NotFoundHandler())
+
+	assert.Equal(t, ":8080", server.Addr)
+	assert.Equal(t, 10*time.Second, server.ReadTimeout)
+	assert.Equal(t, 30*time.Second, server.WriteTimeout)
+	assert.Equal(t, 120*time.Second, server.IdleTimeout)
+}
+
+func TestAdminServerWorkerServesUntilStopped(t *testing.T) {
+	config = defaultConfig()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening on a local port, ", err.Error())
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	ctx, stop := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		adminServerWorker(newAdminServer(handler), listener)(ctx)
+		close(stopped)
+	}()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/admin/loglevel")
+	if err != nil {
+		t.Fatal("Error calling the admin server, ", err.Error())
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	stop()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("admin server didn't stop")
+	}
+}
+
+func TestNewAdminServerUsesAdminPort(t *testing.T) {
+	config = defaultConfig()
+
+	assert.Equal(t, ":8081", newAdminServer(http.NotFoundHandler()).Addr)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// errFileNotFound is returned by a fileStore for a key it has no file under
+var errFileNotFound = errors.New("file not found")
+
+// fileStore keeps the files uploaded with SEFT collection instruments, by key
+type fileStore interface {
+	// Put stores everything read from r under key, replacing any file already there. If reading r fails, nothing is
+	// stored.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns the file stored under key, or errFileNotFound
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes the file stored under key, if there is one
+	Delete(ctx context.Context, key string) error
+}
+
+// instrumentFiles is where SEFT files are kept, set up at startup by openFileStore
+var instrumentFiles fileStore
+
+// openFileStore sets up instrumentFiles in instrument_storage_dir
+func openFileStore() error {
+	store, err := newDirStore(config.Instruments.StorageDir)
+	if err != nil {
+		return err
+	}
+	instrumentFiles = store
+	return nil
+}
+
+// dirStore keeps each file in a directory, named by its key. The directory can be a mounted volume or bucket shared by
+// every replica.
+type dirStore struct {
+	dir string
+}
+
+func newDirStore(dir string) (*dirStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("couldn't create instrument_storage_dir %s: %w", dir, err)
+	}
+	return &dirStore{dir: dir}, nil
+}
+
+func (s *dirStore) path(key string) (string, error) {
+	if key == "" || key[0] == '.' || filepath.Base(key) != key {
+		return "", fmt.Errorf("invalid file key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Put writes to a temporary file and renames it into place, so a file that's being uploaded is never opened
+func (s *dirStore) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("couldn't create a file to upload to: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("couldn't write the uploaded file: %w", err)
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("couldn't store the uploaded file: %w", err)
+	}
+	return nil
+}
+
+func (s *dirStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, errFileNotFound
+	}
+	return f, err
+}
+
+func (s *dirStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirStoreStoresAndDeletesFiles(t *testing.T) {
+	store, err := newDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal("Error creating the store, ", err.Error())
+	}
+	ctx := context.Background()
+
+	assert.NoError(t, store.Put(ctx, "ddc37cb6-c88a-473b-949a-fa5fad9265a1", strings.NewReader("contents")))
+
+	f, err := store.Open(ctx, "ddc37cb6-c88a-473b-949a-fa5fad9265a1")
+	assert.NoError(t, err)
+	contents, _ := ioutil.ReadAll(f)
+	f.Close()
+	assert.Equal(t, "contents", string(contents))
+
+	assert.NoError(t, store.Delete(ctx, "ddc37cb6-c88a-473b-949a-fa5fad9265a1"))
+	_, err = store.Open(ctx, "ddc37cb6-c88a-473b-949a-fa5fad9265a1")
+	assert.Equal(t, errFileNotFound, err)
+	assert.NoError(t, store.Delete(ctx, "ddc37cb6-c88a-473b-949a-fa5fad9265a1"))
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestDirStoreStoresNothingWhenReadingFails(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := newDirStore(dir)
+
+	err := store.Put(context.Background(), "ddc37cb6-c88a-473b-949a-fa5fad9265a1", failingReader{})
+
+	assert.EqualError(t, err, "connection reset")
+	files, _ := ioutil.ReadDir(dir)
+	assert.Empty(t, files)
+}
+
+func TestDirStoreRejectsKeysOutsideItsDirectory(t *testing.T) {
+	store, _ := newDirStore(t.TempDir())
+
+	for _, key := range []string{"", "../survey", "a/b", ".upload-1"} {
+		assert.Error(t, store.Put(context.Background(), key, strings.NewReader("contents")), key)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/ONSdigital/ras-rm-survey"
+
+// configureTracing installs the global tracer provider for the configured exporter. The returned function flushes
+// and stops it. With tracing_exporter set to "none" spans are still created, so trace IDs still reach the logs and
+// outgoing trace context, but nothing is exported.
+func configureTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Tracing.Exporter {
+	case "none":
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		var otlpOptions []otlptracehttp.Option
+		if config.Tracing.OTLPEndpoint != "" {
+			otlpOptions = append(otlpOptions, otlptracehttp.WithEndpoint(config.Tracing.OTLPEndpoint))
+		}
+		if config.Tracing.OTLPInsecure {
+			otlpOptions = append(otlpOptions, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, otlpOptions...)
+	default:
+		return nil, fmt.Errorf("tracing_exporter %q must be one of none, stdout or otlp", config.Tracing.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create the trace exporter: %w", err)
+	}
+
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.Tracing.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(config.ServiceName),
+			semconv.ServiceVersionKey.String(appVersion()),
+		)),
+	}
+	if exporter != nil {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// traceRequests starts a server span for each request, continuing any trace given in the W3C traceparent header
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := routeTemplate(r)
+
+		ctx, span := tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPRouteKey.String(route),
+				semconv.HTTPTargetKey.String(r.URL.RequestURI()),
+			),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, strconv.Itoa(recorder.status))
+		}
+	})
+}
+
+// startQuerySpan starts a client span for a database operation as a child of the span in ctx
+func startQuerySpan(ctx context.Context, operation string) trace.Span {
+	_, span := tracer().Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBNameKey.String(config.DB.Name),
+			attribute.String("db.operation", operation),
+		),
+	)
+	return span
+}
+
+// traceparent is the W3C traceparent of the span in ctx, so that work carried on later from a database row can continue
+// its trace. It's empty if ctx has no span.
+func traceparent(ctx context.Context) string {
+	carrier := propagation.HeaderCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// withTraceparent returns ctx continuing the trace of a stored traceparent, or ctx as it is if there's none
+func withTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	carrier := propagation.HeaderCarrier{}
+	carrier.Set("traceparent", traceparent)
+	return propagation.TraceContext{}.Extract(ctx, carrier)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTracing() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+func TestRequestSpanContinuesIncomingTraceWithQuerySpans(t *testing.T) {
+	setup()
+	recorder := setupTracing()
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	returnRows := mock.NewRows(searchSurveyQueryColumns)
+	returnRows.AddRow("8eb7bdf5-92c2-4c52-8cc8-8f6525301bc5", "123", "TS", "Test Survey", "Test Legal Basis", "SEFT")
+	mock.ExpectQuery(findSurveyQuery).WillReturnRows(returnRows)
+
+	req := httptest.NewRequest("GET", "/survey/123", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	querySpan, requestSpan := spans[0], spans[1]
+
+	assert.Equal(t, "GET /survey/{surveyRef}", requestSpan.Name())
+	assert.Equal(t, trace.SpanKindServer, requestSpan.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", requestSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", requestSpan.Parent().SpanID().String())
+
+	assert.Equal(t, "find_surveys", querySpan.Name())
+	assert.Equal(t, trace.SpanKindClient, querySpan.SpanKind())
+	assert.Equal(t, requestSpan.SpanContext().SpanID(), querySpan.Parent().SpanID())
+}
+
+func TestRequestSpanRecordsServerErrors(t *testing.T) {
+	setup()
+	recorder := setupTracing()
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	mock.ExpectQuery(findSurveyQuery).WillReturnError(sqlmock.ErrCancelled)
+
+	req := httptest.NewRequest("GET", "/survey/123", nil)
+	router.ServeHTTP(resp, req)
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	assert.Equal(t, "Error", spans[0].Status().Code.String())
+	assert.Equal(t, "Error", spans[1].Status().Code.String())
+}
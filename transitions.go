@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"time"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// eventStateChanged is the event_type of exercise_event rows recording a change of state
+const eventStateChanged = "STATE_CHANGED"
+
+// transitionRule moves collection exercises from one state to another once a date has passed
+type transitionRule struct {
+	from string
+	to   string
+	// due is an SQL expression, against collection_exercise aliased as ce, for when the transition is due
+	due string
+}
+
+// allowedTransitions are the states a collection exercise can move to from each state, whether automatically or by
+// hand. Every one of transitionRules is among them.
+var allowedTransitions = map[string][]string{
+	"INIT":              {"CREATED"},
+	"CREATED":           {"SCHEDULED"},
+	"SCHEDULED":         {"READY_FOR_REVIEW"},
+	"READY_FOR_REVIEW":  {"SCHEDULED", "EXECUTION_STARTED"},
+	"EXECUTION_STARTED": {"EXECUTED"},
+	"EXECUTED":          {"VALIDATED", "FAILEDVALIDATION"},
+	"FAILEDVALIDATION":  {"EXECUTION_STARTED"},
+	"VALIDATED":         {"READY_FOR_LIVE"},
+	"READY_FOR_LIVE":    {"LIVE"},
+	"LIVE":              {"ENDED"},
+}
+
+// canTransition reports whether a collection exercise can move straight from one state to another
+func canTransition(from string, to string) bool {
+	for _, next := range allowedTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionRules are the transitions made automatically, in the order they're applied
+var transitionRules = []transitionRule{
+	{from: "READY_FOR_LIVE", to: "LIVE", due: "ce.go_live"},
+	{from: "LIVE", to: "ENDED", due: "COALESCE(ce.return, ce.period_end)"},
+}
+
+// runTransitionWorker applies due transitions every exercise_transitions_interval until ctx is cancelled
+func runTransitionWorker(ctx context.Context) {
+	ticker := time.NewTicker(config.Transitions.Interval)
+	defer ticker.Stop()
+	for {
+		runTransitionPass(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runTransitionPass applies due transitions if no other replica is doing so. Whichever replica takes the transition
+// lock leads the pass; the others skip it and try again at their next tick.
+func runTransitionPass(ctx context.Context) {
+	// Each pass is a trace of its own, which the events it records carry on to where they're published
+	ctx, span := tracer().Start(ctx, "exercise_transitions", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+
+	var transitions []models.Transition
+	led, err := withTransitionLock(ctx, func() (err error) {
+		transitions, err = applyDueTransitions(ctx, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Logger.Error("Couldn't apply collection exercise transitions ", err)
+		}
+		return
+	}
+	if !led {
+		logger.Logger.Debug("Another replica holds the transition lock, skipping this pass")
+		return
+	}
+
+	for _, t := range transitions {
+		exerciseTransitionsTotal.WithLabelValues(t.From, t.To).Inc()
+		logger.Logger.Infow("Moved collection exercise to "+t.To, "exercise_uuid", t.ExerciseUUID, "survey_ref", t.SurveyReference,
+			"period_name", t.PeriodName, "from", t.From, "due", t.Due)
+	}
+}
+
+// transitionLockKey is the Postgres advisory lock key held by the replica applying transitions to the configured schema
+func transitionLockKey() int64 {
+	return int64(crc32.ChecksumIEEE([]byte("ras-rm-survey:transitions:" + config.DB.Schema)))
+}
+
+// withTransitionLock runs fn if it can take the transition lock without waiting, and reports whether it did
+func withTransitionLock(ctx context.Context, fn func() error) (bool, error) {
+	return withTryLock(ctx, transitionLockKey(), fn)
+}
+
+// withTryLock runs fn if it can take the session-level advisory lock key without waiting, and reports whether it did.
+// The lock is released if the connection drops, so a replica that dies mid-pass doesn't block the others.
+func withTryLock(ctx context.Context, key int64, fn func() error) (bool, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("couldn't get a connection for the advisory lock: %w", err)
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		return false, fmt.Errorf("couldn't try the advisory lock: %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			logger.Logger.Warn("Couldn't release the advisory lock ", err)
+		}
+	}()
+
+	return true, fn()
+}
+
+// applyDueTransitions makes every transition due at now, recording an exercise_event for each in the same
+// transaction with the traceparent of the span in ctx, and returns them
+func applyDueTransitions(ctx context.Context, now time.Time) (transitions []models.Transition, err error) {
+	defer observeQuery(ctx, "apply_due_transitions")(&err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Exercise dates are UTC timestamps without a time zone, but occurred_at is a timestamptz, so now is passed as a
+	// timestamptz and converted for the comparison. Left to Postgres, it would be a timestamp, which the insert would
+	// then read in the session's time zone.
+	transitions = []models.Transition{}
+	for _, rule := range transitionRules {
+		query := "WITH moved AS (UPDATE " + schemaTable("collection_exercise") + " ce SET state = $2" +
+			" WHERE ce.state = $1 AND " + rule.due + " <= ($3::timestamptz AT TIME ZONE 'UTC')" +
+			" RETURNING ce.exercise_uuid, ce.survey_ref, COALESCE(ce.period_name, ''), " + rule.due + " AS due)," +
+			" events AS (INSERT INTO " + schemaTable("exercise_event") + " (exercise_uuid, event_type, from_state, to_state, occurred_at, traceparent)" +
+			" SELECT exercise_uuid, '" + eventStateChanged + "', $1, $2, $3::timestamptz, NULLIF($4, '') FROM moved)" +
+			" SELECT * FROM moved"
+
+		moved, err := scanTransitions(tx.QueryContext(ctx, query, rule.from, rule.to, now, traceparent(ctx)))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't move exercises from %s to %s: %w", rule.from, rule.to, err)
+		}
+		for i := range moved {
+			moved[i].From, moved[i].To = rule.from, rule.to
+		}
+		transitions = append(transitions, moved...)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing database transaction: %w", err)
+	}
+	return transitions, nil
+}
+
+// pendingTransitions returns the transitions that would be made at the given time, without making them
+func pendingTransitions(ctx context.Context, at time.Time) (transitions []models.Transition, err error) {
+	defer observeQuery(ctx, "pending_transitions")(&err)
+
+	transitions = []models.Transition{}
+	for _, rule := range transitionRules {
+		query := "SELECT ce.exercise_uuid, ce.survey_ref, COALESCE(ce.period_name, ''), " + rule.due +
+			" FROM " + schemaTable("collection_exercise") + " ce" +
+			" WHERE ce.state = $1 AND " + rule.due + " <= $2 ORDER BY " + rule.due + ", ce.exercise_id"
+
+		pending, err := scanTransitions(db.QueryContext(ctx, query, rule.from, at))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't find exercises due to move from %s to %s: %w", rule.from, rule.to, err)
+		}
+		for i := range pending {
+			pending[i].From, pending[i].To = rule.from, rule.to
+		}
+		transitions = append(transitions, pending...)
+	}
+	return transitions, nil
+}
+
+// scanTransitions reads rows of exercise UUID, survey reference, period name and due date
+func scanTransitions(rows *sql.Rows, err error) ([]models.Transition, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transitions []models.Transition
+	for rows.Next() {
+		var t models.Transition
+		if err := rows.Scan(&t.ExerciseUUID, &t.SurveyReference, &t.PeriodName, &t.Due); err != nil {
+			return nil, fmt.Errorf("error scanning database rows: %w", err)
+		}
+		transitions = append(transitions, t)
+	}
+	return transitions, rows.Err()
+}
+
+// showPendingTransitions is a dry run of the transition worker. It lists the transitions the next pass would make,
+// or that would be due at the time given as ?at=, in RFC 3339.
+func showPendingTransitions(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeRESTError(w, r, http.StatusInternalServerError, models.CodeDatabaseUnavailable, "Database connection could not be found")
+		return
+	}
+
+	at := time.Now().UTC()
+	if param := r.URL.Query().Get("at"); param != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, param); err != nil {
+			writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "at must be an RFC 3339 time, e.g. 2020-09-01T09:00:00Z")
+			return
+		}
+	}
+
+	transitions, err := pendingTransitions(r.Context(), at)
+	if err != nil {
+		writeDBError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(models.PendingTransitions{At: at, Transitions: transitions})
+}
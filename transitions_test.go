@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var transitionColumns = []string{"exercise_uuid", "survey_ref", "period_name", "due"}
+
+func setupTransitions(t *testing.T) sqlmock.Sqlmock {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+	return mock
+}
+
+func TestTransitionRulesAreAllowedTransitions(t *testing.T) {
+	for _, rule := range transitionRules {
+		assert.True(t, canTransition(rule.from, rule.to), "%s to %s isn't in allowedTransitions", rule.from, rule.to)
+	}
+	for from, next := range allowedTransitions {
+		assert.True(t, isExerciseState(from), from)
+		for _, to := range next {
+			assert.True(t, isExerciseState(to), to)
+		}
+	}
+}
+
+func TestApplyDueTransitionsRecordsAnEventForEach(t *testing.T) {
+	mock := setupTransitions(t)
+
+	now := time.Date(2020, 9, 1, 9, 30, 0, 0, time.UTC)
+	goLive := time.Date(2020, 9, 1, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("WITH moved AS \\(UPDATE surveyv2.collection_exercise ce SET state = \\$2 WHERE ce.state = \\$1 AND ce.go_live <= \\(\\$3::timestamptz AT TIME ZONE 'UTC'\\) (.+)INSERT INTO surveyv2.exercise_event (.+) \\$3::timestamptz").
+		WithArgs("READY_FOR_LIVE", "LIVE", now, "").
+		WillReturnRows(mock.NewRows(transitionColumns).AddRow("6f1bf642-2f9c-408f-8ffe-93b40667d99a", "141", "202009", goLive))
+	mock.ExpectQuery("WITH moved AS \\(UPDATE (.+) COALESCE\\(ce.return, ce.period_end\\) <= \\(\\$3::timestamptz AT TIME ZONE 'UTC'\\)").
+		WithArgs("LIVE", "ENDED", now, "").
+		WillReturnRows(mock.NewRows(transitionColumns))
+	mock.ExpectCommit()
+
+	transitions, err := applyDueTransitions(context.Background(), now)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Transition{{ExerciseUUID: "6f1bf642-2f9c-408f-8ffe-93b40667d99a", SurveyReference: "141",
+		PeriodName: "202009", From: "READY_FOR_LIVE", To: "LIVE", Due: goLive}}, transitions)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyDueTransitionsRecordsTheTraceOfTheirEvents(t *testing.T) {
+	mock := setupTransitions(t)
+	setupTracing()
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	ctx, span := tracer().Start(context.Background(), "exercise_transitions")
+	defer span.End()
+	parent := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+	now := time.Date(2020, 9, 1, 9, 30, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO surveyv2.exercise_event \\((.+), traceparent\\) SELECT (.+), NULLIF\\(\\$4, ''\\) FROM moved").
+		WithArgs("READY_FOR_LIVE", "LIVE", now, parent).WillReturnRows(mock.NewRows(transitionColumns))
+	mock.ExpectQuery("WITH moved AS").WithArgs("LIVE", "ENDED", now, parent).WillReturnRows(mock.NewRows(transitionColumns))
+	mock.ExpectCommit()
+
+	_, err := applyDueTransitions(ctx, now)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransitionLockSkipsWhenAnotherReplicaHoldsIt(t *testing.T) {
+	mock := setupTransitions(t)
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").WithArgs(transitionLockKey()).WillReturnRows(mock.NewRows([]string{"locked"}).AddRow(false))
+
+	called := false
+	led, err := withTransitionLock(context.Background(), func() error {
+		called = true
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.False(t, led)
+	assert.False(t, called)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransitionLockReleasesTheLock(t *testing.T) {
+	mock := setupTransitions(t)
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").WithArgs(transitionLockKey()).WillReturnRows(mock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(transitionLockKey()).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	led, err := withTransitionLock(context.Background(), func() error { return nil })
+
+	assert.NoError(t, err)
+	assert.True(t, led)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPendingTransitionsEndpointIsADryRun(t *testing.T) {
+	mock := setupTransitions(t)
+
+	at := time.Date(2020, 9, 30, 0, 0, 0, 0, time.UTC)
+	returnDate := time.Date(2020, 9, 29, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT (.+) WHERE ce.state = \\$1 AND ce.go_live <= \\$2").WithArgs("READY_FOR_LIVE", at).
+		WillReturnRows(mock.NewRows(transitionColumns))
+	mock.ExpectQuery("SELECT (.+) WHERE ce.state = \\$1 AND COALESCE\\(ce.return, ce.period_end\\) <= \\$2").WithArgs("LIVE", at).
+		WillReturnRows(mock.NewRows(transitionColumns).AddRow("6f1bf642-2f9c-408f-8ffe-93b40667d99a", "141", "202009", returnDate))
+
+	req := httptest.NewRequest("GET", "/admin/transitions?at=2020-09-30T00:00:00Z", nil)
+	adminRouter().ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing but the two queries should run")
+
+	var pending models.PendingTransitions
+	err := json.NewDecoder(resp.Body).Decode(&pending)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'GET /admin/transitions', ", err.Error())
+	}
+	assert.True(t, at.Equal(pending.At))
+	assert.Len(t, pending.Transitions, 1)
+	assert.Equal(t, "ENDED", pending.Transitions[0].To)
+}
+
+func TestPendingTransitionsEndpointReturns400WhenAtIsInvalid(t *testing.T) {
+	setupTransitions(t)
+
+	req := httptest.NewRequest("GET", "/admin/transitions?at=tomorrow", nil)
+	adminRouter().ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+
+	"github.com/ONSdigital/ras-rm-survey/logger"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+// openAPIRouter matches requests to operations in the embedded openapi.yaml. The spec is part of the binary, so a
+// spec that doesn't load is a bug and panics at startup, and in every test.
+var openAPIRouter = mustLoadOpenAPI()
+
+func mustLoadOpenAPI() routers.Router {
+	openapi3.DefineStringFormat("uuid", openapi3.FormatOfStringForUUIDOfRFC4122)
+
+	spec, err := docs.ReadFile("openapi.yaml")
+	if err != nil {
+		panic("couldn't read openapi.yaml: " + err.Error())
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		panic("couldn't parse openapi.yaml: " + err.Error())
+	}
+	if err := doc.Validate(openapi3.NewLoader().Context); err != nil {
+		panic("openapi.yaml isn't a valid OpenAPI spec: " + err.Error())
+	}
+
+	// Match requests whatever host they were sent to, rather than the example server in the spec
+	doc.Servers = nil
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		panic("couldn't route openapi.yaml: " + err.Error())
+	}
+	return router
+}
+
+// validateOpenAPI rejects requests that don't match their operation in openapi.yaml with 400. Requests for paths
+// the spec doesn't describe, such as /metrics, pass straight through. With openapi_validate_responses set, as it is
+// in tests, responses are checked too and any that break the contract are replaced with a 500.
+func validateOpenAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := openAPIRouter.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Clients have never had to send a Content-Type, and JSON is the only body the API accepts
+		if r.ContentLength != 0 && r.Header.Get("Content-Type") == "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+
+		// Uploads are streamed to storage and checked by their handlers, rather than being read into memory here
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+				ExcludeRequestBody: mediaType == "multipart/form-data",
+			},
+		}
+		if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			writeRESTError(w, r, http.StatusBadRequest, models.CodeInvalidRequest, err.Error())
+			return
+		}
+
+		if !config.OpenAPIValidateResponses {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buffer := &responseBuffer{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(buffer, r)
+
+		output := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 buffer.status,
+			Header:                 buffer.header,
+			Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+		}
+		output.SetBodyBytes(buffer.body.Bytes())
+		if err := openapi3filter.ValidateResponse(r.Context(), output); err != nil {
+			logger.ForContext(r.Context()).Errorw("Response doesn't match openapi.yaml", "error", err.Error())
+			writeRESTError(w, r, http.StatusInternalServerError, models.CodeInternalError, "Response doesn't match openapi.yaml: "+err.Error())
+			return
+		}
+		buffer.writeTo(w)
+	})
+}
+
+// responseBuffer holds back a response until it has been checked
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *responseBuffer) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
+
+func (b *responseBuffer) writeTo(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
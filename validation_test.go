@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ONSdigital/ras-rm-survey/models"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostSurveyEndpointRejectsBodiesThatBreakTheSpec(t *testing.T) {
+	setup()
+
+	var mock sqlmock.Sqlmock
+	var err error
+
+	db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("Error setting up an SQL mock" + err.Error())
+	}
+
+	var jsonStr = []byte(`{"reference":"156","shortName":"NEWPOST3333","longName":"postsurvey","legalBasis":"Ltest2","surveyMode":"PAPER"}`)
+	req := httptest.NewRequest("POST", "/survey", bytes.NewReader(jsonStr))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	var restError models.RESTError
+	err = json.NewDecoder(resp.Body).Decode(&restError)
+	if err != nil {
+		t.Fatal("Error decoding JSON response from 'POST /survey', ", err.Error())
+	}
+	assert.Equal(t, "INVALID_REQUEST", restError.Code)
+	assert.Contains(t, restError.Message, "surveyMode")
+	assert.NoError(t, mock.ExpectationsWereMet(), "invalid requests shouldn't reach the database")
+}
+
+func TestPostSurveyEndpointRejectsMissingFields(t *testing.T) {
+	setup()
+
+	var jsonStr = []byte(`{"reference":"156","shortName":"NEWPOST3333"}`)
+	req := httptest.NewRequest("POST", "/survey", bytes.NewReader(jsonStr))
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestGetSurveyEndpointRejectsUnknownIncludes(t *testing.T) {
+	setup()
+
+	req := httptest.NewRequest("GET", "/survey/141?include=exercises", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestResponsesThatBreakTheSpecAreReplaced(t *testing.T) {
+	setup()
+
+	drifted := mux.NewRouter()
+	drifted.Use(validateOpenAPI)
+	drifted.HandleFunc("/survey/{surveyRef}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.Write([]byte(`[{"surveyRef":"141"}]`))
+	})
+
+	req := httptest.NewRequest("GET", "/survey/141", nil)
+	drifted.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	assert.Contains(t, resp.Body.String(), "openapi.yaml")
+}
+
+func TestPathsOutsideTheSpecAreNotValidated(t *testing.T) {
+	setup()
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+}